@@ -0,0 +1,40 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Write a file through a temporary file in the same directory which is
+// renamed into place, so readers never see a partially written file. The
+// content is synced before the rename, so a crash can't leave an empty file
+// in place of the old one.
+func writeFileAtomic(path string, write func(io.Writer) error) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"-*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := write(tmp); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
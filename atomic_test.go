@@ -0,0 +1,41 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "waypoint.json")
+	write := func(content string) func(io.Writer) error {
+		return func(w io.Writer) error {
+			_, err := io.WriteString(w, content)
+			return err
+		}
+	}
+
+	if err := writeFileAtomic(path, write("first")); err != nil {
+		t.Fatal(err)
+	}
+
+	// A failing write keeps the previous content
+	failed := errors.New("failed")
+	err := writeFileAtomic(path, func(w io.Writer) error {
+		io.WriteString(w, "partial")
+		return failed
+	})
+	if !errors.Is(err, failed) {
+		t.Fatalf("error = %v, want the write's", err)
+	}
+
+	if data, err := os.ReadFile(path); err != nil || string(data) != "first" {
+		t.Errorf("content = %q, %v, want the first write", data, err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("directory has %d entries, want no temporary files left", len(entries))
+	}
+}
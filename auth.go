@@ -0,0 +1,180 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Secret signing the access cookie, random per start if unset
+const cookieSecretEnv = "TOURMAP_COOKIE_SECRET"
+
+// Cookie holding the signed access code
+const accessCookie = "tourmap_code"
+
+const accessCookieMaxAge = 30 * 24 * time.Hour
+
+// Codes file lines with this prefix hold the hex SHA-256 hash of a code
+const hashedCodePrefix = "sha256:"
+
+// Parse the codes file, one code per line optionally followed by a comma
+// and an RFC3339 expiry. Codes without expiry map to the zero time.
+func parseCodes(data string) map[string]time.Time {
+	codes := make(map[string]time.Time)
+	for _, line := range strings.Split(data, "\n") {
+		code, expiryValue, hasExpiry := strings.Cut(strings.TrimSpace(line), ",")
+		code = strings.TrimSpace(code)
+		if code == "" {
+			continue
+		}
+
+		var expiry time.Time
+		if hasExpiry {
+			var err error
+			expiry, err = time.Parse(time.RFC3339, strings.TrimSpace(expiryValue))
+			if err != nil {
+				slog.Warn("Skipping access code with invalid expiry", "path", codesFile, "error", err)
+				continue
+			}
+		}
+
+		codes[codeKey(code)] = expiry
+	}
+
+	return codes
+}
+
+// Turn a comma or newline separated list of codes into codes file lines. A
+// comma followed by an RFC3339 time still separates a code from its expiry.
+func splitCodeList(value string) string {
+	lines := make([]string, 0)
+	for _, line := range strings.Split(value, "\n") {
+		for _, item := range strings.Split(line, ",") {
+			item = strings.TrimSpace(item)
+			if _, err := time.Parse(time.RFC3339, item); err == nil && len(lines) > 0 {
+				lines[len(lines)-1] += "," + item
+				continue
+			}
+			lines = append(lines, item)
+		}
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// Key a codes file line is stored under, hashes are compared lowercase
+func codeKey(line string) string {
+	if hash, ok := strings.CutPrefix(line, hashedCodePrefix); ok {
+		return hashedCodePrefix + strings.ToLower(hash)
+	}
+	return line
+}
+
+// Key of the hashed form of a code
+func hashedCodeKey(code string) string {
+	sum := sha256.Sum256([]byte(code))
+	return hashedCodePrefix + hex.EncodeToString(sum[:])
+}
+
+// Secret for the access cookie signature, from the environment or random
+func loadCookieSecret(value string) []byte {
+	if value != "" {
+		return []byte(value)
+	}
+
+	secret := make([]byte, 32)
+	rand.Read(secret)
+	return secret
+}
+
+// Access codes the request carries in the X-Access-Code header, the signed
+// cookie or the code query parameter
+func (app *App) requestCodes(r *http.Request) []string {
+	codes := make([]string, 0, 3)
+	if code := r.Header.Get("X-Access-Code"); code != "" {
+		codes = append(codes, code)
+	}
+
+	if cookie, err := r.Cookie(accessCookie); err == nil {
+		if code, ok := app.verifyCookie(cookie.Value); ok {
+			codes = append(codes, code)
+		}
+	}
+
+	if code := r.URL.Query().Get("code"); code != "" {
+		codes = append(codes, code)
+	}
+
+	return codes
+}
+
+// Cookie value of the code followed by its signature
+func (app *App) signCookie(code string) string {
+	mac := hmac.New(sha256.New, app.cookieSecret)
+	mac.Write([]byte(code))
+	return base64.RawURLEncoding.EncodeToString([]byte(code)) + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func (app *App) verifyCookie(value string) (string, bool) {
+	encoded, _, ok := strings.Cut(value, ".")
+	if !ok {
+		return "", false
+	}
+
+	code, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", false
+	}
+
+	return string(code), hmac.Equal([]byte(value), []byte(app.signCookie(string(code))))
+}
+
+// Remember a valid code in a cookie
+func (app *App) setAccessCookie(w http.ResponseWriter, r *http.Request, code string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     accessCookie,
+		Value:    app.signCookie(code),
+		Path:     "/",
+		MaxAge:   int(accessCookieMaxAge.Seconds()),
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// Redirect to the same URL without the code query parameter, after storing
+// a valid code in a cookie. Returns false if there was nothing to strip.
+func (app *App) stripCode(w http.ResponseWriter, r *http.Request) bool {
+	query := r.URL.Query()
+	if !query.Has("code") {
+		return false
+	}
+
+	if code := query.Get("code"); app.validCode(code) {
+		app.setAccessCookie(w, r, code)
+	}
+
+	query.Del("code")
+	target := *r.URL
+	target.RawQuery = query.Encode()
+	http.Redirect(w, r, target.RequestURI(), http.StatusSeeOther)
+	return true
+}
+
+// Handle logins, storing the code in a cookie and redirecting to the map
+func (app *App) handleLogin(w http.ResponseWriter, r *http.Request) {
+	code := r.URL.Query().Get("code")
+	if !app.validCode(code) {
+		http.Error(w, "Invalid code", http.StatusForbidden)
+		return
+	}
+
+	app.setAccessCookie(w, r, code)
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
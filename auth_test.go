@@ -0,0 +1,35 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestCodeExpiry(t *testing.T) {
+	past := time.Now().Add(-time.Hour).Format(time.RFC3339)
+	future := time.Now().Add(time.Hour).Format(time.RFC3339)
+	app := testApp(t.TempDir())
+	app.codes = parseCodes(fmt.Sprintf("forever\nexpired, %s\nvalid,%s\nbroken,next week\n%s%s, %s\n",
+		past, future, hashedCodePrefix, hashedCodeKey("hidden")[len(hashedCodePrefix):], future))
+
+	tests := []struct {
+		code string
+		want bool
+	}{
+		{"forever", true},
+		{"valid", true},
+		{"expired", false},
+		// An invalid expiry drops the code rather than granting access forever
+		{"broken", false},
+		{"hidden", true},
+		{"unknown", false},
+		{"", false},
+	}
+
+	for _, tt := range tests {
+		if got := app.validCode(tt.code); got != tt.want {
+			t.Errorf("validCode(%q) = %v, want %v", tt.code, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"log/slog"
+	"maps"
+	"math"
+	"net/http"
+	"os"
+	"slices"
+	"time"
+)
+
+const checkpointsFile = "./checkpoints.json"
+
+// Radius in km around a checkpoint that counts as passing it
+const checkpointRadiusEnv = "TOURMAP_CHECKPOINT_RADIUS_KM"
+
+const defaultCheckpointRadiusKm = 0.2
+
+// Named location along the route
+type Checkpoint struct {
+	Name string `json:"name"`
+	GPSCoords
+}
+
+// Checkpoint with the time a rider first passed it
+type CheckpointSplit struct {
+	Checkpoint
+	Rider    string     `json:"rider,omitempty"`
+	Reached  bool       `json:"reached"`
+	PassedAt *time.Time `json:"passedAt,omitempty"`
+}
+
+// Load checkpoints from checkpoints.json, a missing file means no checkpoints
+func loadCheckpoints(path string) ([]Checkpoint, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var checkpoints []Checkpoint
+	if err := json.Unmarshal(data, &checkpoints); err != nil {
+		return nil, err
+	}
+
+	return checkpoints, nil
+}
+
+// Compute the first pass of each checkpoint along a single track. A pass is
+// the first run of consecutive points within radiusKm, its time is the one
+// of the point in that run closest to the checkpoint.
+func computeSplits(checkpoints []Checkpoint, waypoints []Waypoint, radiusKm float64) []CheckpointSplit {
+	splits := make([]CheckpointSplit, 0, len(checkpoints))
+	for _, cp := range checkpoints {
+		split := CheckpointSplit{Checkpoint: cp}
+
+		nearest := math.Inf(1)
+		for _, wp := range waypoints {
+			d := distanceKm(cp.Latitude, cp.Longitude, wp.Location.Latitude, wp.Location.Longitude)
+			if d > radiusKm {
+				if split.Reached {
+					break
+				}
+				continue
+			}
+
+			if d < nearest {
+				nearest = d
+				passedAt := wp.Timestamp
+				split.PassedAt = &passedAt
+			}
+			split.Reached = true
+		}
+
+		splits = append(splits, split)
+	}
+
+	return splits
+}
+
+// Handle checkpoint split times
+func (app *App) handleCheckpoints(w http.ResponseWriter, r *http.Request) {
+	checkpoints, err := loadCheckpoints(checkpointsFile)
+	if err != nil {
+		slog.Error("Error loading checkpoints file", "path", checkpointsFile, "error", err)
+		http.Error(w, "Checkpoints error", http.StatusInternalServerError)
+		return
+	}
+
+	// Every rider passes the checkpoints on their own, without a track all
+	// checkpoints are listed as not reached
+	splits := make([]CheckpointSplit, 0)
+	tracks := groupByRider(app.visibleWaypoints(r))
+	if len(tracks) == 0 {
+		tracks[""] = nil
+	}
+	for _, rider := range slices.Sorted(maps.Keys(tracks)) {
+		for _, split := range computeSplits(checkpoints, tracks[rider], app.config.CheckpointRadiusKm) {
+			split.Rider = rider
+			splits = append(splits, split)
+		}
+	}
+	for i := range splits {
+		if splits[i].PassedAt != nil {
+			passedAt := splits[i].PassedAt.In(app.timezones.locate(&splits[i].GPSCoords))
+			splits[i].PassedAt = &passedAt
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(splits)
+}
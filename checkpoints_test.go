@@ -0,0 +1,40 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestComputeSplits(t *testing.T) {
+	// Out to 47.01 and back, a waypoint about 110 m further every minute
+	var waypoints []Waypoint
+	for i := 0; i <= 20; i++ {
+		step := min(i, 20-i)
+		waypoints = append(waypoints, testWaypoint(47+float64(step)*0.001, 11, time.Duration(i)*time.Minute))
+	}
+
+	checkpoints := []Checkpoint{
+		// Passed on the way out and back, closest to the 3 minute waypoint
+		{Name: "bridge", GPSCoords: GPSCoords{Latitude: 47.0031, Longitude: 11}},
+		// Off to the side of the track
+		{Name: "summit", GPSCoords: GPSCoords{Latitude: 47.005, Longitude: 11.01}},
+		// The turning point
+		{Name: "hut", GPSCoords: GPSCoords{Latitude: 47.01, Longitude: 11}},
+	}
+
+	splits := computeSplits(checkpoints, waypoints, 0.2)
+	if len(splits) != len(checkpoints) {
+		t.Fatalf("got %d splits, want %d", len(splits), len(checkpoints))
+	}
+
+	want := []*time.Time{ptr(testStart.Add(3 * time.Minute)), nil, ptr(testStart.Add(10 * time.Minute))}
+	for i, split := range splits {
+		if split.Name != checkpoints[i].Name || split.Reached != (want[i] != nil) {
+			t.Errorf("%s reached %v, want %v", split.Name, split.Reached, want[i] != nil)
+			continue
+		}
+		if want[i] != nil && !split.PassedAt.Equal(*want[i]) {
+			t.Errorf("%s passed at %v, want %v", split.Name, split.PassedAt, want[i])
+		}
+	}
+}
@@ -0,0 +1,466 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Server configuration, read from flags and environment variables
+type Config struct {
+	Addr               string
+	DataDir            string
+	ImagesDir          string
+	GpxDir             string
+	TcxDir             string
+	FitDir             string
+	RouteDir           string
+	ThumbsDir          string
+	ImageExts          map[string]struct{}
+	Tracking           TrackingRequest
+	OsmAndDevices      map[string]string
+	TimeZone           *time.Location
+	DetectTimeZone     bool
+	Restriction        RestrictionMode
+	CheckpointRadiusKm float64
+	Palette            []string
+	MergeOverlapping   bool
+	PruneDistanceKm    float64
+	SimplifyMeters     float64
+	SmoothWindow       int
+	MaxSpeedKmh        float64
+	SegmentGap         time.Duration
+	AnonRadiusKm       float64
+	Dev                bool
+	LogLevel           slog.Level
+	FetchTimeout       time.Duration
+	GeocodeURL         string
+	MinMoveKm          float64
+	MinInterval        time.Duration
+	ImageInterval      time.Duration
+	TrackInterval      time.Duration
+	MaxWaypoints       int
+	CORSOrigins        []string
+	TLSCert            string
+	TLSKey             string
+	AutocertDomain     string
+	AutocertCache      string
+	Center             *GPSCoords
+	Zoom               int
+}
+
+// Value of the environment variable or the fallback if unset
+func envOr(key, fallback string) string {
+	if value, ok := os.LookupEnv(key); ok && value != "" {
+		return value
+	}
+
+	return fallback
+}
+
+// Collects all configuration problems so they can be reported at once
+type configProblems []error
+
+func (p *configProblems) add(setting string, err error) {
+	if err != nil {
+		*p = append(*p, fmt.Errorf("%s: %w", setting, err))
+	}
+}
+
+// Report listing every problem on its own line
+func (p configProblems) err() error {
+	if len(p) == 0 {
+		return nil
+	}
+
+	lines := make([]string, 0, len(p))
+	for _, problem := range p {
+		lines = append(lines, "  - "+problem.Error())
+	}
+
+	return fmt.Errorf("invalid configuration:\n%s", strings.Join(lines, "\n"))
+}
+
+// Number, duration or boolean in the environment variable, or the fallback if
+// unset. Invalid values are reported as problems of the variable.
+func (p *configProblems) envFloat(key string, fallback float64) float64 {
+	value, err := strconv.ParseFloat(envOr(key, strconv.FormatFloat(fallback, 'f', -1, 64)), 64)
+	if err != nil {
+		p.add(key, fmt.Errorf("invalid number %q", os.Getenv(key)))
+		return fallback
+	}
+
+	return value
+}
+
+func (p *configProblems) envInt(key string, fallback int) int {
+	value, err := strconv.Atoi(envOr(key, strconv.Itoa(fallback)))
+	if err != nil {
+		p.add(key, fmt.Errorf("invalid integer %q", os.Getenv(key)))
+		return fallback
+	}
+
+	return value
+}
+
+func (p *configProblems) envDuration(key string, fallback time.Duration) time.Duration {
+	value, err := time.ParseDuration(envOr(key, fallback.String()))
+	if err != nil {
+		p.add(key, fmt.Errorf("invalid duration %q", os.Getenv(key)))
+		return fallback
+	}
+
+	return value
+}
+
+func (p *configProblems) envBool(key string, fallback bool) bool {
+	value, err := strconv.ParseBool(envOr(key, strconv.FormatBool(fallback)))
+	if err != nil {
+		p.add(key, fmt.Errorf("invalid boolean %q", os.Getenv(key)))
+		return fallback
+	}
+
+	return value
+}
+
+// Load and validate the configuration. Every setting is a flag, which falls
+// back to an environment variable. Secrets like access codes and tracking
+// tokens are only read from the environment or their files, so they don't
+// show up in process lists. All problems are collected into the returned
+// error instead of stopping at the first one.
+func loadConfig(args []string) (*Config, error) {
+	var problems configProblems
+	flags := flag.NewFlagSet("tour-map", flag.ExitOnError)
+	addr := flags.String("addr", envOr("TOURMAP_ADDR", ":8080"), "HTTP listen address, also read from TOURMAP_ADDR")
+	data := flags.String("data", envOr("TOURMAP_DATA_DIR", defaultDataDir), "waypoint data directory, also read from TOURMAP_DATA_DIR")
+	images := flags.String("images", envOr("TOURMAP_IMAGES_DIR", defaultImagesDir), "image directory, also read from TOURMAP_IMAGES_DIR")
+	imageExts := flags.String("image-extensions", envOr(imageExtensionsEnv, strings.Join(defaultImageExtensions, ",")), "comma-separated image extensions to scan, also read from "+imageExtensionsEnv)
+	gpx := flags.String("gpx", envOr("TOURMAP_GPX_DIR", defaultGpxDir), "GPX import directory, also read from TOURMAP_GPX_DIR")
+	tcx := flags.String("tcx", envOr("TOURMAP_TCX_DIR", defaultTcxDir), "TCX import directory, also read from TOURMAP_TCX_DIR")
+	fit := flags.String("fit", envOr("TOURMAP_FIT_DIR", defaultFitDir), "FIT import directory, also read from TOURMAP_FIT_DIR")
+	route := flags.String("route", envOr("TOURMAP_ROUTE_DIR", defaultRouteDir), "planned route directory with GPX and GeoJSON files, also read from TOURMAP_ROUTE_DIR")
+	thumbs := flags.String("thumbs", envOr("TOURMAP_THUMBS_DIR", defaultThumbsDir), "thumbnail cache directory, also read from TOURMAP_THUMBS_DIR")
+	mergeOverlapping := flags.Bool("merge-overlapping", problems.envBool(mergeOverlappingEnv, false), "drop imported tracks that duplicate another imported track, also read from "+mergeOverlappingEnv)
+	pruneDistance := flags.Float64("prune-distance", problems.envFloat("TOURMAP_PRUNE_DISTANCE", 20), "minimum distance in meters between kept waypoints, 0 disables pruning, also read from TOURMAP_PRUNE_DISTANCE")
+	simplify := flags.Float64("simplify", problems.envFloat("TOURMAP_SIMPLIFY", 0), "simplify tracks with Douglas-Peucker using this tolerance in meters instead of distance pruning, 0 disables it, also read from TOURMAP_SIMPLIFY")
+	smooth := flags.Int("smooth", problems.envInt("TOURMAP_SMOOTH", 0), "average positions over this many waypoints to even out GPS jitter before pruning, 0 disables smoothing, also read from TOURMAP_SMOOTH")
+	maxSpeed := flags.Float64("max-speed", problems.envFloat("TOURMAP_MAX_SPEED", 200), "speed in km/h above which a waypoint far off both its neighbors is dropped as a GPS spike, 0 disables the check, also read from TOURMAP_MAX_SPEED")
+	segmentGap := flags.Duration("segment-gap", problems.envDuration("TOURMAP_SEGMENT_GAP", defaultSegmentGap), "time between waypoints that starts a new track segment, also read from TOURMAP_SEGMENT_GAP")
+	restriction := flags.String("restriction-mode", envOr(restrictionModeEnv, string(RestrictRadius)), "how the latest part of the track is hidden from viewers without a code, radius or path, also read from "+restrictionModeEnv)
+	anonRadius := flags.Float64("anon-radius", problems.envFloat("TOURMAP_ANON_RADIUS", defaultRestrictionKm), "distance in km around the latest position hidden from viewers without a code, also read from TOURMAP_ANON_RADIUS")
+	checkpointRadius := flags.Float64("checkpoint-radius", problems.envFloat(checkpointRadiusEnv, defaultCheckpointRadiusKm), "distance in km around a checkpoint that counts as passing it, also read from "+checkpointRadiusEnv)
+	palette := flags.String("palette", envOr(paletteEnv, strings.Join(defaultPalette, ",")), "comma-separated hex colors of the riders' tracks, also read from "+paletteEnv)
+	timezone := flags.String("timezone", envOr(timezoneEnv, "UTC"), "IANA timezone of presented times when detection is disabled or fails, also read from "+timezoneEnv)
+	detectTimezone := flags.Bool("detect-timezone", problems.envBool(detectTimezoneEnv, false), "present times in the timezone of the current position, also read from "+detectTimezoneEnv)
+	geocodeURL := flags.String("geocode-url", os.Getenv(geocodeURLEnv), "Nominatim-compatible base URL for reverse geocoding, disabled if empty, also read from "+geocodeURLEnv)
+	corsOrigin := flags.String("cors-origin", os.Getenv("TOURMAP_CORS_ORIGIN"), "comma-separated origins allowed to call the API from other sites, * allows any, empty disables CORS, also read from TOURMAP_CORS_ORIGIN")
+	maxWaypoints := flags.Int("max-waypoints", problems.envInt("TOURMAP_MAX_WAYPOINTS", 0), "maximum number of waypoints kept in memory, older ones are thinned out first, 0 disables the limit, also read from TOURMAP_MAX_WAYPOINTS")
+	imageInterval := flags.Duration("image-interval", problems.envDuration("TOURMAP_IMAGE_INTERVAL", 300*time.Second), "time between image directory scans, also read from TOURMAP_IMAGE_INTERVAL")
+	trackInterval := flags.Duration("track-interval", problems.envDuration("TOURMAP_TRACK_INTERVAL", 15*time.Second), "time between tracking provider polls, also read from TOURMAP_TRACK_INTERVAL")
+	minMove := flags.Float64("min-move", problems.envFloat("TOURMAP_MIN_MOVE", 10), "distance in meters a new waypoint has to be away from the previous one, unless min-interval passed, also read from TOURMAP_MIN_MOVE")
+	minInterval := flags.Duration("min-interval", problems.envDuration("TOURMAP_MIN_INTERVAL", time.Minute), "time after which a new waypoint is stored even without movement, also read from TOURMAP_MIN_INTERVAL")
+	trackingURL := flags.String("tracking-url", envOr("TOURMAP_TRACKING_URL", defaultTrackingURL), "tracking request URL template, "+tokenPlaceholder+" is replaced with the token, also read from TOURMAP_TRACKING_URL")
+	osmandDevices := flags.String("osmand-devices", os.Getenv("TOURMAP_OSMAND_DEVICES"), "comma-separated device ids allowed to send positions to /api/osmand, as id or id=rider, also read from TOURMAP_OSMAND_DEVICES")
+	fetchTimeout := flags.Duration("fetch-timeout", problems.envDuration("TOURMAP_FETCH_TIMEOUT", 10*time.Second), "timeout of requests to the tracking provider, also read from TOURMAP_FETCH_TIMEOUT")
+	logLevel := flags.String("log-level", envOr("TOURMAP_LOG_LEVEL", "info"), "minimum log level: debug, info, warn or error, also read from TOURMAP_LOG_LEVEL")
+	tlsCert := flags.String("tls-cert", os.Getenv("TOURMAP_TLS_CERT"), "TLS certificate file, serves HTTPS together with tls-key, also read from TOURMAP_TLS_CERT")
+	tlsKey := flags.String("tls-key", os.Getenv("TOURMAP_TLS_KEY"), "TLS private key file, serves HTTPS together with tls-cert, also read from TOURMAP_TLS_KEY")
+	autocertDomain := flags.String("autocert-domain", os.Getenv("TOURMAP_AUTOCERT_DOMAIN"), "domain to serve HTTPS for with certificates from Let's Encrypt, needs addr to be reachable on port 443, also read from TOURMAP_AUTOCERT_DOMAIN")
+	autocertCache := flags.String("autocert-cache", envOr("TOURMAP_AUTOCERT_CACHE", defaultAutocertCache), "directory for certificates obtained with autocert-domain, also read from TOURMAP_AUTOCERT_CACHE")
+	center := flags.String("center", os.Getenv("TOURMAP_CENTER"), "initial map center as lat,lng, the latest waypoint is shown if empty, also read from TOURMAP_CENTER")
+	zoom := flags.Int("zoom", problems.envInt("TOURMAP_ZOOM", 13), "initial map zoom level used with center, also read from TOURMAP_ZOOM")
+	dev := flags.Bool("dev", problems.envBool("TOURMAP_DEV", false), "read index.html from the working directory on every request, also read from TOURMAP_DEV")
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+
+	var err error
+	cfg := &Config{
+		Addr:               *addr,
+		DataDir:            *data,
+		ImagesDir:          *images,
+		GpxDir:             *gpx,
+		TcxDir:             *tcx,
+		FitDir:             *fit,
+		RouteDir:           *route,
+		ThumbsDir:          *thumbs,
+		ImageExts:          parseImageExtensions(*imageExts),
+		DetectTimeZone:     *detectTimezone,
+		CheckpointRadiusKm: *checkpointRadius,
+		MergeOverlapping:   *mergeOverlapping,
+		PruneDistanceKm:    *pruneDistance / 1000,
+		SimplifyMeters:     *simplify,
+		SmoothWindow:       *smooth,
+		MaxSpeedKmh:        *maxSpeed,
+		SegmentGap:         *segmentGap,
+		AnonRadiusKm:       *anonRadius,
+		Dev:                *dev,
+		FetchTimeout:       *fetchTimeout,
+		OsmAndDevices:      parseOsmAndDevices(*osmandDevices),
+		GeocodeURL:         *geocodeURL,
+		MinMoveKm:          *minMove / 1000,
+		MinInterval:        *minInterval,
+		ImageInterval:      *imageInterval,
+		TrackInterval:      *trackInterval,
+		MaxWaypoints:       *maxWaypoints,
+		CORSOrigins:        parseOrigins(*corsOrigin),
+		TLSCert:            *tlsCert,
+		TLSKey:             *tlsKey,
+		AutocertDomain:     *autocertDomain,
+		AutocertCache:      *autocertCache,
+		Zoom:               *zoom,
+	}
+
+	if cfg.Addr == "" {
+		problems.add("addr", errors.New("must not be empty"))
+	}
+
+	if cfg.PruneDistanceKm < 0 {
+		problems.add("prune-distance", errors.New("must not be negative"))
+	}
+
+	if cfg.SimplifyMeters < 0 {
+		problems.add("simplify", errors.New("must not be negative"))
+	}
+
+	if cfg.SmoothWindow < 0 {
+		problems.add("smooth", errors.New("must not be negative"))
+	}
+
+	if cfg.MaxSpeedKmh < 0 {
+		problems.add("max-speed", errors.New("must not be negative"))
+	}
+
+	if cfg.SegmentGap <= 0 {
+		problems.add("segment-gap", errors.New("must be positive"))
+	}
+
+	if cfg.MaxWaypoints < 0 {
+		problems.add("max-waypoints", errors.New("must not be negative"))
+	}
+
+	if cfg.ImageInterval <= 0 {
+		problems.add("image-interval", errors.New("must be positive"))
+	}
+
+	if cfg.TrackInterval <= 0 {
+		problems.add("track-interval", errors.New("must be positive"))
+	}
+
+	if cfg.MinMoveKm < 0 {
+		problems.add("min-move", errors.New("must not be negative"))
+	}
+
+	if cfg.MinInterval < 0 {
+		problems.add("min-interval", errors.New("must not be negative"))
+	}
+
+	if cfg.FetchTimeout <= 0 {
+		problems.add("fetch-timeout", errors.New("must be positive"))
+	}
+
+	if cfg.AnonRadiusKm < 0 {
+		problems.add("anon-radius", errors.New("must not be negative"))
+	}
+
+	if (cfg.TLSCert == "") != (cfg.TLSKey == "") {
+		problems.add("tls-cert", errors.New("tls-cert and tls-key must be set together"))
+	}
+
+	if cfg.AutocertDomain != "" && cfg.TLSCert != "" {
+		problems.add("autocert-domain", errors.New("cannot be combined with tls-cert"))
+	}
+
+	if *center != "" {
+		cfg.Center, err = parseCenter(*center)
+		problems.add("center", err)
+	}
+
+	if cfg.Zoom < 0 || cfg.Zoom > 19 {
+		problems.add("zoom", errors.New("must be between 0 and 19"))
+	}
+
+	if err := cfg.LogLevel.UnmarshalText([]byte(*logLevel)); err != nil {
+		problems.add("log-level", fmt.Errorf("unknown level %q", *logLevel))
+	}
+
+	if cfg.GeocodeURL != "" {
+		if parsed, err := url.Parse(cfg.GeocodeURL); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") {
+			problems.add("geocode-url", errors.New("must be an http or https URL"))
+		}
+	}
+
+	cfg.Tracking, err = trackingRequestFromEnv(*trackingURL)
+	problems.add("TOURMAP_TRACKING_*", err)
+
+	cfg.TimeZone, err = time.LoadLocation(*timezone)
+	problems.add("timezone", err)
+
+	cfg.Restriction, err = parseRestrictionMode(*restriction)
+	problems.add("restriction-mode", err)
+
+	if cfg.CheckpointRadiusKm <= 0 {
+		problems.add("checkpoint-radius", errors.New("must be positive"))
+	}
+
+	cfg.Palette, err = parsePalette(*palette)
+	problems.add("palette", err)
+
+	for _, dir := range []string{cfg.DataDir, cfg.ImagesDir, cfg.GpxDir, cfg.TcxDir, cfg.FitDir, cfg.RouteDir, cfg.ThumbsDir, cfg.AutocertCache} {
+		problems.add(dir, checkDirectory(dir))
+	}
+
+	return cfg, problems.err()
+}
+
+// Parse a number that must be greater than zero
+func parsePositiveFloat(value string) (float64, error) {
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid number %q", value)
+	}
+
+	if f <= 0 {
+		return 0, fmt.Errorf("must be positive, got %v", f)
+	}
+
+	return f, nil
+}
+
+// Directories are optional, but if the path exists it has to be a directory
+func checkDirectory(path string) error {
+	info, err := os.Stat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	if !info.IsDir() {
+		return errors.New("not a directory")
+	}
+
+	return nil
+}
+
+// Smooth the tracks of all riders if configured. Only applied once when
+// waypoints are loaded, as reducing tracks happens again on every merge.
+func (cfg *Config) smoothTracks(waypoints []Waypoint) []Waypoint {
+	if cfg.SmoothWindow < 2 {
+		return waypoints
+	}
+
+	sorted := slices.Clone(waypoints)
+	slices.SortStableFunc(sorted, func(a, b Waypoint) int {
+		return a.Timestamp.Compare(b.Timestamp)
+	})
+
+	smoothed := make([]Waypoint, 0, len(sorted))
+	for _, track := range groupByRider(sorted) {
+		smoothed = append(smoothed, smoothWaypoints(track, cfg.SmoothWindow)...)
+	}
+
+	return smoothed
+}
+
+// Thin out a track, simplifying it if configured and pruning it otherwise.
+// GPS spikes are dropped first.
+func (cfg *Config) reduceTrack(waypoints []Waypoint) []Waypoint {
+	waypoints = rejectOutliers(waypoints, cfg.MaxSpeedKmh)
+	if cfg.SimplifyMeters > 0 {
+		return simplifyDouglasPeucker(waypoints, cfg.SimplifyMeters)
+	}
+
+	return pruneWaypoints(waypoints, cfg.PruneDistanceKm)
+}
+
+// Whether a new waypoint moved far enough from the previous one or enough
+// time passed to be worth storing
+func (cfg *Config) significantChange(previous, next Waypoint) bool {
+	if next.Timestamp.Sub(previous.Timestamp) >= cfg.MinInterval {
+		return true
+	}
+
+	a, b := previous.Location, next.Location
+	return distanceKm(a.Latitude, a.Longitude, b.Latitude, b.Longitude) >= cfg.MinMoveKm
+}
+
+// Parse a map center given as lat,lng
+func parseCenter(value string) (*GPSCoords, error) {
+	lat, lng, ok := strings.Cut(value, ",")
+	if !ok {
+		return nil, fmt.Errorf("expected lat,lng, got %q", value)
+	}
+
+	var coords GPSCoords
+	var errLat, errLng error
+	coords.Latitude, errLat = strconv.ParseFloat(strings.TrimSpace(lat), 64)
+	coords.Longitude, errLng = strconv.ParseFloat(strings.TrimSpace(lng), 64)
+	if errLat != nil || errLng != nil || !validCoords(coords) {
+		return nil, fmt.Errorf("invalid coordinates %q", value)
+	}
+
+	return &coords, nil
+}
+
+// Split a comma-separated origin list, dropping empty entries
+func parseOrigins(list string) []string {
+	origins := make([]string, 0)
+	for _, origin := range strings.Split(list, ",") {
+		if origin = strings.TrimSuffix(strings.TrimSpace(origin), "/"); origin != "" {
+			origins = append(origins, origin)
+		}
+	}
+
+	return origins
+}
+
+// Whether requests from the origin may read API responses
+func (cfg *Config) allowsOrigin(origin string) bool {
+	if origin == "" {
+		return false
+	}
+
+	return slices.ContainsFunc(cfg.CORSOrigins, func(allowed string) bool {
+		return allowed == "*" || allowed == origin
+	})
+}
+
+// Public part of the configuration returned by /api/config, never contains
+// codes or tracking tokens
+type PublicConfig struct {
+	AnonRadiusKm    float64         `json:"anonRadiusKm"`
+	RestrictionMode RestrictionMode `json:"restrictionMode"`
+	PruneDistanceM  float64         `json:"pruneDistanceM"`
+	SimplifyM       float64         `json:"simplifyM"`
+	// Durations in seconds
+	SegmentGap    int64 `json:"segmentGap"`
+	TrackInterval int64 `json:"trackInterval"`
+	ImageInterval int64 `json:"imageInterval"`
+}
+
+// Handle the settings clients adapt to, like the hidden radius and how often
+// new data can arrive
+func (app *App) handleConfig(w http.ResponseWriter, r *http.Request) {
+	cfg := app.config
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(PublicConfig{
+		AnonRadiusKm:    cfg.AnonRadiusKm,
+		RestrictionMode: cfg.Restriction,
+		PruneDistanceM:  cfg.PruneDistanceKm * 1000,
+		SimplifyM:       cfg.SimplifyMeters,
+		SegmentGap:      int64(cfg.SegmentGap.Seconds()),
+		TrackInterval:   int64(cfg.TrackInterval.Seconds()),
+		ImageInterval:   int64(cfg.ImageInterval.Seconds()),
+	})
+}
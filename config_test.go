@@ -0,0 +1,57 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLoadConfigDefaults(t *testing.T) {
+	cfg, err := loadConfig(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.Addr != ":8080" || cfg.PruneDistanceKm != 0.02 || cfg.Restriction != RestrictRadius {
+		t.Errorf("defaults = %q, %v, %q", cfg.Addr, cfg.PruneDistanceKm, cfg.Restriction)
+	}
+}
+
+func TestLoadConfigInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		env  map[string]string
+		want []string
+	}{
+		{"negative flag", []string{"-prune-distance", "-5"}, nil, []string{"prune-distance: must not be negative"}},
+		{"zero duration", []string{"-segment-gap", "0s"}, nil, []string{"segment-gap: must be positive"}},
+		{"unknown mode", []string{"-restriction-mode", "circle"}, nil, []string{"restriction-mode: unknown mode"}},
+		{"unknown timezone", []string{"-timezone", "Mars/Olympus"}, nil, []string{"timezone:"}},
+		{"half TLS", []string{"-tls-cert", "cert.pem"}, nil, []string{"tls-cert: tls-cert and tls-key"}},
+		{"invalid env number", nil, map[string]string{"TOURMAP_PRUNE_DISTANCE": "abc"}, []string{`TOURMAP_PRUNE_DISTANCE: invalid number "abc"`}},
+		{"invalid env duration", nil, map[string]string{"TOURMAP_SEGMENT_GAP": "soon"}, []string{`TOURMAP_SEGMENT_GAP: invalid duration "soon"`}},
+		{"all at once", []string{"-zoom", "25", "-log-level", "loud", "-geocode-url", "ftp://example.com"}, nil, []string{
+			"zoom: must be between 0 and 19",
+			`log-level: unknown level "loud"`,
+			"geocode-url: must be an http or https URL",
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for key, value := range tt.env {
+				t.Setenv(key, value)
+			}
+
+			_, err := loadConfig(tt.args)
+			if err == nil {
+				t.Fatal("invalid configuration accepted")
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("error %q doesn't report %q", err, want)
+				}
+			}
+		})
+	}
+}
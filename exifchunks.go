@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+var errNoEXIF = errors.New("no EXIF found")
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// Prefix some writers put in front of the TIFF data
+var exifHeader = []byte("Exif\x00\x00")
+
+// Read the EXIF data from the eXIf chunk of a PNG file
+func pngEXIF(r io.Reader) ([]byte, error) {
+	signature := make([]byte, len(pngSignature))
+	if _, err := io.ReadFull(r, signature); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(signature, pngSignature) {
+		return nil, errors.New("not a PNG file")
+	}
+
+	header := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			return nil, err
+		}
+		length := int64(binary.BigEndian.Uint32(header[:4]))
+
+		switch string(header[4:]) {
+		case "eXIf":
+			return readChunk(r, length)
+		case "IDAT", "IEND":
+			// eXIf has to come before the image data
+			return nil, errNoEXIF
+		}
+
+		// Skip the chunk data and its CRC
+		if _, err := io.CopyN(io.Discard, r, length+4); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// Read the EXIF data from the EXIF chunk of a WebP file
+func webpEXIF(r io.Reader) ([]byte, error) {
+	header := make([]byte, 12)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	if string(header[:4]) != "RIFF" || string(header[8:]) != "WEBP" {
+		return nil, errors.New("not a WebP file")
+	}
+
+	header = header[:8]
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil, errNoEXIF
+			}
+			return nil, err
+		}
+		length := int64(binary.LittleEndian.Uint32(header[4:]))
+
+		if string(header[:4]) == "EXIF" {
+			return readChunk(r, length)
+		}
+
+		// Chunks are padded to an even size
+		if _, err := io.CopyN(io.Discard, r, length+length%2); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// Read chunk data, dropping an Exif header in front of the TIFF data
+func readChunk(r io.Reader, length int64) ([]byte, error) {
+	data := make([]byte, 0, min(length, 1<<20))
+	buf := bytes.NewBuffer(data)
+	if _, err := io.CopyN(buf, r, length); err != nil {
+		return nil, err
+	}
+
+	return bytes.TrimPrefix(buf.Bytes(), exifHeader), nil
+}
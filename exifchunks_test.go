@@ -0,0 +1,31 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestExtractGPSCoordsPNG(t *testing.T) {
+	app := &App{}
+	location, err := app.extractGPSCoords("testdata/gps.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !nearCoords(location.Coords, 47.2692, 11.4041) {
+		t.Errorf("coords = %+v, want 47.2692, 11.4041", location.Coords)
+	}
+}
+
+func TestPNGWithoutEXIF(t *testing.T) {
+	file, err := os.Open("testdata/no-exif.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	if _, err := pngEXIF(file); !errors.Is(err, errNoEXIF) {
+		t.Errorf("err = %v, want errNoEXIF", err)
+	}
+}
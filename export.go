@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"maps"
+	"net/http"
+	"slices"
+	"time"
+)
+
+// GeoJSON feature. orb's geometries only have two dimensions, tracks are
+// written with their own geometry to carry elevation as third coordinate.
+type geoJSONFeature struct {
+	Type       string          `json:"type"`
+	Geometry   geoJSONGeometry `json:"geometry"`
+	Properties map[string]any  `json:"properties"`
+}
+
+type geoJSONGeometry struct {
+	Type        string `json:"type"`
+	Coordinates any    `json:"coordinates"`
+}
+
+type geoJSONFeatureCollection struct {
+	Type     string           `json:"type"`
+	Features []geoJSONFeature `json:"features"`
+}
+
+// GeoJSON position of the coordinates, [lng, lat] or [lng, lat, elevation]
+func geoJSONPosition(c *GPSCoords) []float64 {
+	if c.Elevation != nil {
+		return []float64{c.Longitude, c.Latitude, *c.Elevation}
+	}
+
+	return []float64{c.Longitude, c.Latitude}
+}
+
+// Handle the visible tracks and image locations as a GeoJSON
+// FeatureCollection with a LineString per rider
+func (app *App) handleGeoJSON(w http.ResponseWriter, r *http.Request) {
+	fc := geoJSONFeatureCollection{Type: "FeatureCollection", Features: make([]geoJSONFeature, 0)}
+
+	tracks := groupByRider(app.visibleWaypoints(r))
+	for _, rider := range slices.Sorted(maps.Keys(tracks)) {
+		waypoints := tracks[rider]
+		line := make([][]float64, 0, len(waypoints))
+		for _, wp := range waypoints {
+			line = append(line, geoJSONPosition(wp.Location))
+		}
+		track := geoJSONFeature{
+			Type:     "Feature",
+			Geometry: geoJSONGeometry{Type: "LineString", Coordinates: line},
+			Properties: map[string]any{
+				"name":      "track",
+				"startTime": waypoints[0].Timestamp,
+				"endTime":   waypoints[len(waypoints)-1].Timestamp,
+			},
+		}
+		if rider != "" {
+			track.Properties["rider"] = rider
+		}
+		fc.Features = append(fc.Features, track)
+	}
+
+	images := imageCoords(app.visibleImages(r), time.Time{}, time.Time{})
+	for _, filename := range slices.Sorted(maps.Keys(images)) {
+		coords := images[filename]
+		fc.Features = append(fc.Features, geoJSONFeature{
+			Type:     "Feature",
+			Geometry: geoJSONGeometry{Type: "Point", Coordinates: []float64{coords[1], coords[0]}},
+			Properties: map[string]any{
+				"name": filename,
+				"url":  "/images/" + filename,
+			},
+		})
+	}
+
+	data, err := json.Marshal(fc)
+	if err != nil {
+		http.Error(w, "JSON encoding error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/geo+json")
+	w.Write(data)
+}
+
+// Handle the visible track as a GPX download
+func (app *App) handleGPX(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/gpx+xml")
+	w.Header().Set("Content-Disposition", `attachment; filename="track.gpx"`)
+	if err := writeGPX(w, app.visibleWaypoints(r)); err != nil {
+		slog.Error("Error writing GPX export", "error", err)
+	}
+}
+
+// Handle the visible track and image locations as a KML download for Google
+// Earth
+func (app *App) handleKML(w http.ResponseWriter, r *http.Request) {
+	// Google Earth needs absolute URLs to show the images
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	imageBase := scheme + "://" + r.Host + "/images/"
+
+	w.Header().Set("Content-Type", "application/vnd.google-earth.kml+xml")
+	w.Header().Set("Content-Disposition", `attachment; filename="track.kml"`)
+	if err := writeKML(w, app.visibleWaypoints(r), imageCoords(app.visibleImages(r), time.Time{}, time.Time{}), imageBase); err != nil {
+		slog.Error("Error writing KML export", "error", err)
+	}
+}
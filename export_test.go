@@ -0,0 +1,46 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/paulmach/orb/geojson"
+)
+
+func TestGeoJSONElevation(t *testing.T) {
+	app := testApp(t.TempDir())
+	app.codes["secret"] = time.Time{}
+	app.waypoints = []Waypoint{
+		testElevated(47.0, 11.0, 600, 0),
+		testWaypoint(47.1, 11.1, time.Minute),
+		testElevated(47.2, 11.2, 612.5, 2*time.Minute),
+	}
+
+	rec := httptest.NewRecorder()
+	app.handleGeoJSON(rec, httptest.NewRequest(http.MethodGet, "/api/track.geojson?code=secret", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d, want 200", rec.Code)
+	}
+
+	body := rec.Body.String()
+	for _, position := range []string{"[11,47,600]", "[11.1,47.1]", "[11.2,47.2,612.5]"} {
+		if !strings.Contains(body, position) {
+			t.Errorf("position %s missing in %s", position, body)
+		}
+	}
+
+	// Generic GeoJSON readers still get the track
+	fc, err := geojson.UnmarshalFeatureCollection(rec.Body.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fc.Features) != 1 || fc.Features[0].Geometry.GeoJSONType() != "LineString" {
+		t.Fatalf("features = %+v, want the track", fc.Features)
+	}
+	if got := fc.Features[0].Properties["name"]; got != "track" {
+		t.Errorf("name = %v, want track", got)
+	}
+}
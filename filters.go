@@ -0,0 +1,206 @@
+package main
+
+import (
+	"math"
+	"slices"
+)
+
+// Whether the coordinates are within range and not exactly (0, 0), which
+// broken devices report when they have no fix. NaN is out of range, GPX
+// files may contain it as it parses as a number.
+func validCoords(c GPSCoords) bool {
+	if !(math.Abs(c.Latitude) <= 90) || !(math.Abs(c.Longitude) <= 180) {
+		return false
+	}
+
+	return c.Latitude != 0 || c.Longitude != 0
+}
+
+// Drop waypoints closer than minDistanceKm to the previously kept one. The
+// first and last waypoint are always kept, a distance of 0 disables pruning.
+func pruneWaypoints(waypoints []Waypoint, minDistanceKm float64) []Waypoint {
+	if minDistanceKm <= 0 || len(waypoints) < 3 {
+		return waypoints
+	}
+
+	pruned := make([]Waypoint, 0, len(waypoints))
+	pruned = append(pruned, waypoints[0])
+	for _, wp := range waypoints[1 : len(waypoints)-1] {
+		last := pruned[len(pruned)-1].Location
+		if distanceKmFast(last.Latitude, last.Longitude, wp.Location.Latitude, wp.Location.Longitude) >= minDistanceKm {
+			pruned = append(pruned, wp)
+		}
+	}
+
+	return append(pruned, waypoints[len(waypoints)-1])
+}
+
+// Drop single waypoints that could only be reached from both neighbors faster
+// than maxSpeedKmh, like a position on another continent between two normal
+// ones. The first and last waypoint are kept as they have only one
+// neighbor, a speed of 0 disables the check. Live waypoints are checked
+// against the previous one as they arrive, see recordWaypoint.
+func rejectOutliers(waypoints []Waypoint, maxSpeedKmh float64) []Waypoint {
+	if maxSpeedKmh <= 0 || len(waypoints) < 3 {
+		return waypoints
+	}
+
+	kept := make([]Waypoint, 0, len(waypoints))
+	kept = append(kept, waypoints[0])
+	for i := 1; i < len(waypoints)-1; i++ {
+		prev, wp, next := kept[len(kept)-1], waypoints[i], waypoints[i+1]
+		if speedKmh(prev, wp) > maxSpeedKmh && speedKmh(wp, next) > maxSpeedKmh {
+			continue
+		}
+		kept = append(kept, wp)
+	}
+
+	return append(kept, waypoints[len(waypoints)-1])
+}
+
+// Replace each position with the average over a centered window of window
+// waypoints, which evens out GPS jitter. The window shrinks towards the ends
+// of the track, the first and last waypoint keep their position. A window
+// below 2 disables smoothing.
+func smoothWaypoints(waypoints []Waypoint, window int) []Waypoint {
+	if window < 2 || len(waypoints) < 3 {
+		return waypoints
+	}
+
+	half := window / 2
+	smoothed := make([]Waypoint, len(waypoints))
+	copy(smoothed, waypoints)
+	for i := 1; i < len(waypoints)-1; i++ {
+		// Shrink symmetrically, so points near the ends aren't pulled inward
+		reach := min(half, i, len(waypoints)-1-i)
+
+		var lat, lng float64
+		for _, wp := range waypoints[i-reach : i+reach+1] {
+			lat += wp.Location.Latitude
+			lng += wp.Location.Longitude
+		}
+		count := float64(2*reach + 1)
+
+		// Locations are shared with other slices, so don't modify them
+		coords := *waypoints[i].Location
+		coords.Latitude = lat / count
+		coords.Longitude = lng / count
+		smoothed[i].Location = &coords
+	}
+
+	return smoothed
+}
+
+// Reduce a track with the Douglas-Peucker algorithm, keeping every waypoint
+// that deviates more than epsilonMeters from the simplified line. The first
+// and last waypoint are always kept, an epsilon of 0 disables simplification.
+func simplifyDouglasPeucker(waypoints []Waypoint, epsilonMeters float64) []Waypoint {
+	if epsilonMeters <= 0 || len(waypoints) < 3 {
+		return waypoints
+	}
+
+	// Project onto a local plane in meters, accurate enough at track scale
+	origin := waypoints[0].Location
+	scale := math.Cos(origin.Latitude * math.Pi / 180)
+	points := make([][2]float64, len(waypoints))
+	for i, wp := range waypoints {
+		points[i] = [2]float64{
+			(wp.Location.Longitude - origin.Longitude) * scale * metersPerDegree,
+			(wp.Location.Latitude - origin.Latitude) * metersPerDegree,
+		}
+	}
+
+	keep := make([]bool, len(waypoints))
+	keep[0] = true
+	keep[len(waypoints)-1] = true
+
+	// Iterate instead of recursing, long recordings would go deep
+	stack := [][2]int{{0, len(waypoints) - 1}}
+	for len(stack) > 0 {
+		span := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		farthest, maxDist := -1, epsilonMeters
+		for i := span[0] + 1; i < span[1]; i++ {
+			if d := segmentDistance(points[i], points[span[0]], points[span[1]]); d > maxDist {
+				farthest, maxDist = i, d
+			}
+		}
+
+		if farthest >= 0 {
+			keep[farthest] = true
+			stack = append(stack, [2]int{span[0], farthest}, [2]int{farthest, span[1]})
+		}
+	}
+
+	simplified := make([]Waypoint, 0, len(waypoints))
+	for i, wp := range waypoints {
+		if keep[i] {
+			simplified = append(simplified, wp)
+		}
+	}
+
+	return simplified
+}
+
+// Length of one degree of latitude in meters
+const metersPerDegree = 111320.0
+
+// Distance from p to the segment between a and b
+func segmentDistance(p, a, b [2]float64) float64 {
+	_, distance := projectOnSegment(p, a, b)
+	return distance
+}
+
+// Position of the point on the segment between a and b closest to p, as the
+// fraction of the way from a to b, and its distance to p
+func projectOnSegment(p, a, b [2]float64) (float64, float64) {
+	dx, dy := b[0]-a[0], b[1]-a[1]
+	t := 0.0
+	if length := dx*dx + dy*dy; length > 0 {
+		t = ((p[0]-a[0])*dx + (p[1]-a[1])*dy) / length
+		t = max(0, min(1, t))
+	}
+
+	return t, math.Hypot(p[0]-a[0]-t*dx, p[1]-a[1]-t*dy)
+}
+
+// Thin out older waypoints so at most maxCount remain. The most recent half
+// of every rider's share is kept as is, older ones are simplified with a
+// growing tolerance so the shape of the track is preserved. A maxCount of 0
+// disables the limit.
+func capWaypoints(waypoints []Waypoint, maxCount int) []Waypoint {
+	if maxCount <= 0 || len(waypoints) <= maxCount {
+		return waypoints
+	}
+
+	// Trim below the limit, so not every new waypoint triggers this again
+	tracks := groupByRider(waypoints)
+	budget := max(maxCount*3/4/len(tracks), 4)
+
+	capped := make([]Waypoint, 0, maxCount)
+	for _, track := range tracks {
+		if len(track) <= budget {
+			capped = append(capped, track...)
+			continue
+		}
+
+		recent := track[len(track)-budget/2:]
+		older := track[:len(track)-budget/2]
+		for epsilon := 10.0; len(older) > budget-len(recent); epsilon *= 2 {
+			older = simplifyDouglasPeucker(older, epsilon)
+			if len(older) <= 2 {
+				break
+			}
+		}
+
+		capped = append(capped, older...)
+		capped = append(capped, recent...)
+	}
+
+	slices.SortStableFunc(capped, func(a, b Waypoint) int {
+		return a.Timestamp.Compare(b.Timestamp)
+	})
+
+	return capped
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"math"
+	"math/rand/v2"
+	"slices"
+	"testing"
+	"time"
+)
+
+// Track of n waypoints one second and a few meters apart with some jitter,
+// like a long recording at full resolution
+func syntheticTrack(n int) []Waypoint {
+	rng := rand.New(rand.NewPCG(1, 2))
+	waypoints := make([]Waypoint, n)
+	for i := range waypoints {
+		lat := 47.0 + float64(i)*0.00004 + rng.NormFloat64()*0.00001
+		lng := 11.0 + float64(i)*0.00003 + rng.NormFloat64()*0.00001
+		waypoints[i] = testWaypoint(lat, lng, time.Duration(i)*time.Second)
+	}
+
+	return waypoints
+}
+
+func BenchmarkPruneWaypoints(b *testing.B) {
+	waypoints := syntheticTrack(100_000)
+	for b.Loop() {
+		pruneWaypoints(waypoints, 0.02)
+	}
+}
+
+func TestSimplifyDouglasPeucker(t *testing.T) {
+	// Track heading north about 110 m per waypoint, every other waypoint
+	// offset east by offsetMeters
+	track := func(offsetMeters float64) []Waypoint {
+		var waypoints []Waypoint
+		for i := range 21 {
+			lng := 11.0
+			if i%2 == 1 {
+				lng += offsetMeters / (metersPerDegree * math.Cos(47*math.Pi/180))
+			}
+			waypoints = append(waypoints, testWaypoint(47+float64(i)*0.001, lng, time.Duration(i)*time.Minute))
+		}
+		return waypoints
+	}
+
+	tests := []struct {
+		name         string
+		offsetMeters float64
+		want         int
+	}{
+		{"straight", 0, 2},
+		{"jitter below epsilon", 20, 2},
+		{"zigzag", 200, 21},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			waypoints := track(tt.offsetMeters)
+			simplified := simplifyDouglasPeucker(waypoints, 50)
+			if len(simplified) != tt.want {
+				t.Errorf("kept %d waypoints, want %d", len(simplified), tt.want)
+			}
+			if simplified[0] != waypoints[0] || simplified[len(simplified)-1] != waypoints[len(waypoints)-1] {
+				t.Error("first or last waypoint dropped")
+			}
+		})
+	}
+}
+
+func TestValidCoords(t *testing.T) {
+	tests := []struct {
+		lat, lng float64
+		want     bool
+	}{
+		{47.0, 11.0, true},
+		{90, 180, true},
+		{-90, -180, true},
+		{0, 11.0, true},
+		{47.0, 0, true},
+		{0, 0, false},
+		{90.0001, 0, false},
+		{-90.0001, 11.0, false},
+		{47.0, 180.0001, false},
+		{47.0, -180.0001, false},
+		{math.NaN(), 11.0, false},
+	}
+
+	for _, tt := range tests {
+		if got := validCoords(GPSCoords{Latitude: tt.lat, Longitude: tt.lng}); got != tt.want {
+			t.Errorf("validCoords(%v, %v) = %v, want %v", tt.lat, tt.lng, got, tt.want)
+		}
+	}
+}
+
+func TestSmoothWaypointsNoisyCluster(t *testing.T) {
+	// Standing still at 47.0, 11.0 with GPS noise of about 20 m, then riding
+	// north from 2 km away on a straight road, a point every 100 m
+	rng := rand.New(rand.NewPCG(3, 4))
+	waypoints := make([]Waypoint, 0, 80)
+	for i := range 50 {
+		waypoints = append(waypoints, testWaypoint(47+rng.NormFloat64()*0.0002, 11+rng.NormFloat64()*0.0002, time.Duration(i)*time.Minute))
+	}
+	for i := range 30 {
+		waypoints = append(waypoints, testWaypoint(47.018+float64(i)*0.0009, 11, time.Duration(50+i)*time.Minute))
+	}
+	original := *waypoints[10].Location
+
+	// Mean distance of the inner cluster waypoints to the true position
+	spread := func(track []Waypoint) float64 {
+		total := 0.0
+		for _, wp := range track[1:45] {
+			total += distanceKm(47, 11, wp.Location.Latitude, wp.Location.Longitude)
+		}
+		return total / 44
+	}
+
+	smoothed := smoothWaypoints(waypoints, 9)
+	if len(smoothed) != len(waypoints) {
+		t.Fatalf("smoothed %d waypoints, want %d", len(smoothed), len(waypoints))
+	}
+	if before, after := spread(waypoints), spread(smoothed); after > before/2 {
+		t.Errorf("mean error %.1f m after smoothing, want below half of %.1f m", after*1000, before*1000)
+	}
+
+	// The road beyond the window's reach of the cluster stays in place
+	for i := 55; i < len(waypoints); i++ {
+		a, b := waypoints[i].Location, smoothed[i].Location
+		if moved := distanceKm(a.Latitude, a.Longitude, b.Latitude, b.Longitude); moved > 0.003 {
+			t.Errorf("waypoint %d on the road moved %.1f m", i, moved*1000)
+		}
+	}
+
+	if smoothed[0].Location != waypoints[0].Location || smoothed[79].Location != waypoints[79].Location {
+		t.Error("first or last waypoint moved")
+	}
+	if *waypoints[10].Location != original {
+		t.Error("input waypoint modified")
+	}
+}
+
+func TestRejectOutliersSpike(t *testing.T) {
+	// Riding north at 30 km/h with one position 50 km east in between
+	var waypoints []Waypoint
+	for i := range 10 {
+		waypoints = append(waypoints, testWaypoint(47+float64(i)*0.0045, 11, time.Duration(i)*time.Minute))
+	}
+	waypoints[5].Location.Longitude += 50 / (111.2 * math.Cos(47*math.Pi/180))
+
+	kept := rejectOutliers(waypoints, 200)
+	if len(kept) != 9 {
+		t.Fatalf("kept %d waypoints, want 9", len(kept))
+	}
+	for _, wp := range kept {
+		if wp.Location.Longitude != 11 {
+			t.Errorf("spike at %v kept", wp.Location)
+		}
+	}
+
+	// Without the spike, nothing is dropped
+	waypoints = slices.Delete(waypoints, 5, 6)
+	if kept := rejectOutliers(waypoints, 200); len(kept) != len(waypoints) {
+		t.Errorf("kept %d of %d regular waypoints", len(kept), len(waypoints))
+	}
+}
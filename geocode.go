@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Nominatim-compatible base URL, reverse geocoding is disabled if unset
+const geocodeURLEnv = "TOURMAP_GEOCODE_URL"
+
+// Failed lookups are retried after this long
+const geocodeRetryDelay = time.Minute
+
+// Reverse geocoder caching place names by coordinates rounded to about 1km
+type Geocoder struct {
+	baseURL string
+	client  *http.Client
+	mutex   sync.Mutex
+	cache   map[string]geocodeEntry
+}
+
+type geocodeEntry struct {
+	name      string
+	retryFrom time.Time
+}
+
+// Response of the Nominatim reverse endpoint, only the parts we need
+type nominatimResponse struct {
+	DisplayName string `json:"display_name"`
+	Address     struct {
+		City    string `json:"city"`
+		Town    string `json:"town"`
+		Village string `json:"village"`
+		State   string `json:"state"`
+		Country string `json:"country"`
+	} `json:"address"`
+}
+
+func newGeocoder(baseURL string, client *http.Client) *Geocoder {
+	return &Geocoder{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		client:  client,
+		cache:   make(map[string]geocodeEntry),
+	}
+}
+
+// Place name near the coordinates, empty if disabled or the lookup failed
+func (g *Geocoder) placeName(c *GPSCoords) string {
+	if g == nil || g.baseURL == "" {
+		return ""
+	}
+
+	key := fmt.Sprintf("%.2f,%.2f", math.Round(c.Latitude*100)/100, math.Round(c.Longitude*100)/100)
+
+	// Lookups are serialized, which also keeps us within the rate limits
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	if entry, ok := g.cache[key]; ok && (entry.name != "" || time.Now().Before(entry.retryFrom)) {
+		return entry.name
+	}
+
+	name, err := g.lookup(c)
+	if err != nil {
+		slog.Warn("Error reverse geocoding position", "lat", c.Latitude, "lng", c.Longitude, "error", err)
+		g.cache[key] = geocodeEntry{retryFrom: time.Now().Add(geocodeRetryDelay)}
+		return ""
+	}
+
+	g.cache[key] = geocodeEntry{name: name}
+	return name
+}
+
+func (g *Geocoder) lookup(c *GPSCoords) (string, error) {
+	query := url.Values{
+		"format": {"jsonv2"},
+		"lat":    {fmt.Sprint(c.Latitude)},
+		"lon":    {fmt.Sprint(c.Longitude)},
+		"zoom":   {"10"},
+	}
+
+	req, err := http.NewRequest(http.MethodGet, g.baseURL+"/reverse?"+query.Encode(), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("User-Agent", "tour-map")
+
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", &statusError{StatusCode: resp.StatusCode, Status: resp.Status}
+	}
+
+	var place nominatimResponse
+	if err := json.NewDecoder(resp.Body).Decode(&place); err != nil {
+		return "", fmt.Errorf("decoding JSON: %w", err)
+	}
+
+	return place.name(), nil
+}
+
+// Short name like "Innsbruck, Austria", falling back to the display name
+func (place nominatimResponse) name() string {
+	address := place.Address
+	parts := make([]string, 0, 2)
+	for _, locality := range []string{address.City, address.Town, address.Village, address.State} {
+		if locality != "" {
+			parts = append(parts, locality)
+			break
+		}
+	}
+	if address.Country != "" {
+		parts = append(parts, address.Country)
+	}
+
+	if len(parts) == 0 {
+		return place.DisplayName
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Response of /api/location
+type LocationResponse struct {
+	Lat       float64   `json:"lat"`
+	Lng       float64   `json:"lng"`
+	UpdatedAt time.Time `json:"updatedAt"`
+	Name      string    `json:"name,omitempty"`
+}
+
+// Handle the latest visible position with its place name
+func (app *App) handleLocation(w http.ResponseWriter, r *http.Request) {
+	waypoints := app.visibleWaypoints(r)
+	if len(waypoints) == 0 {
+		http.Error(w, "No position available", http.StatusNotFound)
+		return
+	}
+
+	latest := waypoints[len(waypoints)-1]
+	response := LocationResponse{
+		Lat:       latest.Location.Latitude,
+		Lng:       latest.Location.Longitude,
+		UpdatedAt: latest.Timestamp,
+		Name:      app.geocoder.placeName(latest.Location),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
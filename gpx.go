@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/xml"
+	"io"
+	"maps"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// GPX document, only the parts needed for tracks
+type gpxFile struct {
+	XMLName xml.Name   `xml:"gpx"`
+	Xmlns   string     `xml:"xmlns,attr,omitempty"`
+	Version string     `xml:"version,attr,omitempty"`
+	Creator string     `xml:"creator,attr,omitempty"`
+	Tracks  []gpxTrack `xml:"trk"`
+	Routes  []gpxRoute `xml:"rte"`
+}
+
+type gpxTrack struct {
+	Name     string       `xml:"name,omitempty"`
+	Segments []gpxSegment `xml:"trkseg"`
+}
+
+// Planned route, points usually have no time
+type gpxRoute struct {
+	Name   string     `xml:"name,omitempty"`
+	Points []gpxPoint `xml:"rtept"`
+}
+
+type gpxSegment struct {
+	Points []gpxPoint `xml:"trkpt"`
+}
+
+type gpxPoint struct {
+	Lat        string         `xml:"lat,attr"`
+	Lon        string         `xml:"lon,attr"`
+	Ele        string         `xml:"ele,omitempty"`
+	Time       string         `xml:"time,omitempty"`
+	Extensions *gpxExtensions `xml:"extensions,omitempty"`
+}
+
+// Garmin TrackPointExtension sensor data
+type gpxExtensions struct {
+	HeartRate string `xml:"TrackPointExtension>hr,omitempty"`
+	Cadence   string `xml:"TrackPointExtension>cad,omitempty"`
+}
+
+// Write waypoints as GPX with a track of a single segment per rider
+func writeGPX(w io.Writer, waypoints []Waypoint) error {
+	doc := gpxFile{
+		Xmlns:   "http://www.topografix.com/GPX/1/1",
+		Version: "1.1",
+		Creator: "tour-map",
+		Tracks:  make([]gpxTrack, 0),
+	}
+
+	tracks := groupByRider(waypoints)
+	for _, rider := range slices.Sorted(maps.Keys(tracks)) {
+		segment := gpxSegment{Points: make([]gpxPoint, 0, len(tracks[rider]))}
+		for _, wp := range tracks[rider] {
+			point := gpxPoint{
+				Lat: strconv.FormatFloat(wp.Location.Latitude, 'f', -1, 64),
+				Lon: strconv.FormatFloat(wp.Location.Longitude, 'f', -1, 64),
+			}
+
+			if wp.Location.Elevation != nil {
+				point.Ele = strconv.FormatFloat(*wp.Location.Elevation, 'f', -1, 64)
+			}
+
+			// Leave out the time rather than claiming year 1
+			if !wp.Timestamp.IsZero() {
+				point.Time = wp.Timestamp.UTC().Format(time.RFC3339)
+			}
+
+			segment.Points = append(segment.Points, point)
+		}
+
+		name := rider
+		if name == "" {
+			name = "Tour"
+		}
+		doc.Tracks = append(doc.Tracks, gpxTrack{
+			Name:     name,
+			Segments: []gpxSegment{segment},
+		})
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	return enc.Encode(doc)
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteGPXRoundTrip(t *testing.T) {
+	waypoints := []Waypoint{
+		testElevated(47.0, 11.0, 600, 0),
+		testElevated(47.01, 11.02, 612.5, 90*time.Second),
+		testWaypoint(47.02, 11.04, 3*time.Minute),
+	}
+
+	var buf bytes.Buffer
+	if err := writeGPX(&buf, waypoints); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "track.gpx")
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	parsed, err := parseGpxFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parsed) != len(waypoints) {
+		t.Fatalf("parsed %d waypoints, want %d", len(parsed), len(waypoints))
+	}
+	for i, wp := range parsed {
+		if !wp.Timestamp.Equal(waypoints[i].Timestamp) {
+			t.Errorf("waypoint %d time = %v, want %v", i, wp.Timestamp, waypoints[i].Timestamp)
+		}
+		got, want := wp.Location, waypoints[i].Location
+		if got.Latitude != want.Latitude || got.Longitude != want.Longitude {
+			t.Errorf("waypoint %d at %v, %v, want %v, %v", i, got.Latitude, got.Longitude, want.Latitude, want.Longitude)
+		}
+		if (got.Elevation == nil) != (want.Elevation == nil) || got.Elevation != nil && *got.Elevation != *want.Elevation {
+			t.Errorf("waypoint %d elevation = %v, want %v", i, got.Elevation, want.Elevation)
+		}
+	}
+}
+
+func TestWriteGPXOmitsZeroTime(t *testing.T) {
+	waypoints := []Waypoint{
+		testWaypoint(47.0, 11.0, 0),
+		{Location: &GPSCoords{Latitude: 47.01, Longitude: 11.02}},
+	}
+
+	var buf bytes.Buffer
+	if err := writeGPX(&buf, waypoints); err != nil {
+		t.Fatal(err)
+	}
+
+	gpx := buf.String()
+	if count := strings.Count(gpx, "<time>"); count != 1 {
+		t.Errorf("wrote %d times, want only the one of the first waypoint:\n%s", count, gpx)
+	}
+	if strings.Contains(gpx, "0001-01-01") {
+		t.Errorf("wrote the zero time:\n%s", gpx)
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Entry of /api/images
+type ImageInfo struct {
+	Filename string     `json:"filename"`
+	Lat      float64    `json:"lat"`
+	Lng      float64    `json:"lng"`
+	ThumbURL string     `json:"thumbUrl"`
+	Taken    *time.Time `json:"taken,omitempty"`
+}
+
+// Group of images taken close to each other
+type ImageCluster struct {
+	// Center of the member images
+	Lat       float64  `json:"lat"`
+	Lng       float64  `json:"lng"`
+	Count     int      `json:"count"`
+	Filenames []string `json:"filenames"`
+}
+
+// Handle the list of geotagged images, ordered by capture time. Viewers
+// without a code don't get the images in the hidden area.
+//
+// Query parameters:
+//
+//	cluster  distance in meters, images this close are returned as one
+//	         ImageCluster entry instead
+func (app *App) handleImages(w http.ResponseWriter, r *http.Request) {
+	images := imageList(app.visibleImages(r))
+
+	var response any = images
+	if value := r.URL.Query().Get("cluster"); value != "" {
+		meters, err := parsePositiveFloat(value)
+		if err != nil {
+			http.Error(w, "Invalid cluster distance", http.StatusBadRequest)
+			return
+		}
+		response = clusterImages(images, meters/1000)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// Content types of image formats Go doesn't know
+var imageContentTypes = map[string]string{
+	".heic": "image/heic",
+	".heif": "image/heif",
+	".tif":  "image/tiff",
+	".tiff": "image/tiff",
+	".webp": "image/webp",
+}
+
+// Handle files of the image directory with cache control headers. Only files
+// with an image extension are served, other files and directory listings are
+// not found.
+func (app *App) handleImageFile(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/images/")
+	hidden := slices.ContainsFunc(strings.Split(name, "/"), func(part string) bool {
+		return part == "" || strings.HasPrefix(part, ".")
+	})
+	if hidden || !app.isImageFile(name) {
+		http.NotFound(w, r)
+		return
+	}
+
+	file := filepath.Join(app.config.ImagesDir, filepath.FromSlash(path.Clean("/"+name)))
+	info, err := os.Stat(file)
+	if err != nil || !info.Mode().IsRegular() {
+		http.NotFound(w, r)
+		return
+	}
+
+	ext := strings.ToLower(filepath.Ext(name))
+	contentType := mime.TypeByExtension(ext)
+	if known, ok := imageContentTypes[ext]; ok {
+		contentType = known
+	}
+	if contentType != "" {
+		w.Header().Set("Content-Type", contentType)
+	}
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Cache-Control", "public, max-age=259200")
+	http.ServeFile(w, r, file)
+}
+
+// Geotagged images ordered by capture time. Images without a capture time
+// come last, ties are ordered by filename.
+func imageList(locations map[string]ImageLocation) []ImageInfo {
+	images := make([]ImageInfo, 0, len(locations))
+	for filename, location := range locations {
+		info := ImageInfo{
+			Filename: filename,
+			Lat:      location.Coords.Latitude,
+			Lng:      location.Coords.Longitude,
+			ThumbURL: "/thumbs/" + url.PathEscape(filename),
+		}
+		if !location.Taken.IsZero() {
+			taken := location.Taken
+			info.Taken = &taken
+		}
+		images = append(images, info)
+	}
+
+	slices.SortFunc(images, func(a, b ImageInfo) int {
+		switch {
+		case a.Taken == nil && b.Taken != nil:
+			return 1
+		case a.Taken != nil && b.Taken == nil:
+			return -1
+		case a.Taken != nil && !a.Taken.Equal(*b.Taken):
+			return a.Taken.Compare(*b.Taken)
+		}
+		return cmp.Compare(a.Filename, b.Filename)
+	})
+
+	return images
+}
+
+// Group images within radiusKm of the first image of a cluster, keeping the
+// order of the images within and across clusters
+func clusterImages(images []ImageInfo, radiusKm float64) []ImageCluster {
+	clusters := make([]ImageCluster, 0)
+	anchors := make([]ImageInfo, 0)
+	for _, image := range images {
+		i := slices.IndexFunc(anchors, func(anchor ImageInfo) bool {
+			return distanceKm(anchor.Lat, anchor.Lng, image.Lat, image.Lng) <= radiusKm
+		})
+		if i < 0 {
+			anchors = append(anchors, image)
+			clusters = append(clusters, ImageCluster{})
+			i = len(clusters) - 1
+		}
+
+		// Running mean of the member coordinates
+		cluster := &clusters[i]
+		cluster.Count++
+		cluster.Lat += (image.Lat - cluster.Lat) / float64(cluster.Count)
+		cluster.Lng += (image.Lng - cluster.Lng) / float64(cluster.Count)
+		cluster.Filenames = append(cluster.Filenames, image.Filename)
+	}
+
+	return clusters
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"math"
+	"slices"
+	"testing"
+)
+
+func TestClusterImages(t *testing.T) {
+	// Three images within a few meters at a viewpoint, one 5 km away
+	images := []ImageInfo{
+		{Filename: "a.jpg", Lat: 47.0000, Lng: 11.0000},
+		{Filename: "b.jpg", Lat: 47.0003, Lng: 11.0000},
+		{Filename: "outlier.jpg", Lat: 47.0450, Lng: 11.0000},
+		{Filename: "c.jpg", Lat: 47.0000, Lng: 11.0003},
+	}
+
+	clusters := clusterImages(images, 0.05)
+	if len(clusters) != 2 {
+		t.Fatalf("got %d clusters, want 2", len(clusters))
+	}
+
+	viewpoint, outlier := clusters[0], clusters[1]
+	if !slices.Equal(viewpoint.Filenames, []string{"a.jpg", "b.jpg", "c.jpg"}) || viewpoint.Count != 3 {
+		t.Errorf("first cluster = %v, want a, b and c", viewpoint.Filenames)
+	}
+	if math.Abs(viewpoint.Lat-47.0001) > 1e-9 || math.Abs(viewpoint.Lng-11.0001) > 1e-9 {
+		t.Errorf("first cluster at %v, %v, want the mean 47.0001, 11.0001", viewpoint.Lat, viewpoint.Lng)
+	}
+	if !slices.Equal(outlier.Filenames, []string{"outlier.jpg"}) || outlier.Lat != 47.045 {
+		t.Errorf("second cluster = %+v, want only the outlier at its position", outlier)
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// Number of ingest ids remembered for deduplicating retries
+const maxSeenIDs = 10000
+
+// Bounded set of ids, forgetting the oldest ones once full
+type idSet struct {
+	ids   map[string]struct{}
+	order []string
+	limit int
+}
+
+func newIDSet(limit int) *idSet {
+	return &idSet{
+		ids:   make(map[string]struct{}),
+		limit: limit,
+	}
+}
+
+func (s *idSet) contains(id string) bool {
+	_, ok := s.ids[id]
+	return ok
+}
+
+func (s *idSet) add(id string) {
+	if s.contains(id) {
+		return
+	}
+
+	if len(s.order) >= s.limit {
+		delete(s.ids, s.order[0])
+		s.order = s.order[1:]
+	}
+
+	s.ids[id] = struct{}{}
+	s.order = append(s.order, id)
+}
+
+// Body of /api/ingest, a waypoint with an optional client-supplied id
+type ingestRequest struct {
+	ID string `json:"id"`
+	Waypoint
+}
+
+// Handle pushed waypoints. Requests carrying an id that was already
+// ingested are accepted without adding the waypoint again, so clients can
+// safely retry.
+func (app *App) handleIngest(w http.ResponseWriter, r *http.Request) {
+	if !app.hasAccess(r) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	var req ingestRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid waypoint", http.StatusBadRequest)
+		return
+	}
+
+	if req.Location == nil || req.Timestamp.IsZero() {
+		http.Error(w, "Waypoint requires location and updatedAt", http.StatusBadRequest)
+		return
+	}
+
+	if !validCoords(*req.Location) {
+		http.Error(w, "Invalid coordinates", http.StatusBadRequest)
+		return
+	}
+
+	wp := req.Waypoint
+	wp.Timestamp = wp.Timestamp.UTC()
+	wp.IngestID = req.ID
+	app.recordWaypoint(wp)
+
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIngestDuplicateID(t *testing.T) {
+	dataDir := t.TempDir()
+	ingest := func(app *App, body string) {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPost, "/api/ingest", strings.NewReader(body))
+		req.Header.Set("X-Access-Code", "secret")
+		rec := httptest.NewRecorder()
+		app.handleIngest(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status %d, want 200", rec.Code)
+		}
+	}
+
+	app := testApp(dataDir)
+	app.codes["secret"] = time.Time{}
+	ingest(app, `{"id":"ride-1","location":{"lat":47.0,"lng":11.0},"updatedAt":"2024-06-01T08:00:00Z"}`)
+	// A retry of the same request, with a newer time it would be stored if
+	// it weren't for the id
+	ingest(app, `{"id":"ride-1","location":{"lat":47.1,"lng":11.1},"updatedAt":"2024-06-01T08:05:00Z"}`)
+	if len(app.waypoints) != 1 || app.waypoints[0].IngestID != "ride-1" {
+		t.Fatalf("stored %+v, want the first waypoint only", app.waypoints)
+	}
+
+	// After a restart the id is known from the persisted waypoint
+	restarted := testApp(dataDir)
+	restarted.codes["secret"] = time.Time{}
+	restarted.loadWaypoints()
+	if len(restarted.waypoints) != 1 || !restarted.seenIDs.contains("ride-1") {
+		t.Fatalf("reloaded %d waypoints, id seen %v, want 1 and seen", len(restarted.waypoints), restarted.seenIDs.contains("ride-1"))
+	}
+	ingest(restarted, `{"id":"ride-1","location":{"lat":47.2,"lng":11.2},"updatedAt":"2024-06-01T08:10:00Z"}`)
+	if len(restarted.waypoints) != 1 {
+		t.Errorf("stored %d waypoints after the retry, want 1", len(restarted.waypoints))
+	}
+}
+
+func TestIDSetForgetsOldest(t *testing.T) {
+	ids := newIDSet(2)
+	ids.add("a")
+	ids.add("b")
+	ids.add("a")
+	ids.add("c")
+
+	if ids.contains("a") || !ids.contains("b") || !ids.contains("c") {
+		t.Errorf("contains a %v, b %v, c %v, want only b and c", ids.contains("a"), ids.contains("b"), ids.contains("c"))
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/xml"
+	"io"
+	"maps"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// KML document with a line per rider and a point per image
+type kmlFile struct {
+	XMLName  xml.Name `xml:"kml"`
+	Xmlns    string   `xml:"xmlns,attr"`
+	Document struct {
+		Name       string         `xml:"name"`
+		Placemarks []kmlPlacemark `xml:"Placemark"`
+	} `xml:"Document"`
+}
+
+type kmlPlacemark struct {
+	Name        string         `xml:"name"`
+	Description string         `xml:"description,omitempty"`
+	LineString  *kmlLineString `xml:"LineString,omitempty"`
+	Point       *kmlPoint      `xml:"Point,omitempty"`
+}
+
+type kmlLineString struct {
+	Tessellate  int    `xml:"tessellate"`
+	Coordinates string `xml:"coordinates"`
+}
+
+type kmlPoint struct {
+	Coordinates string `xml:"coordinates"`
+}
+
+// KML coordinate tuple, lng,lat with the altitude if known
+func kmlCoordinates(c GPSCoords) string {
+	coords := strconv.FormatFloat(c.Longitude, 'f', -1, 64) + "," + strconv.FormatFloat(c.Latitude, 'f', -1, 64)
+	if c.Elevation != nil {
+		coords += "," + strconv.FormatFloat(*c.Elevation, 'f', -1, 64)
+	}
+
+	return coords
+}
+
+// Write the waypoints as one line per rider and the images as points linking
+// to imageBase + filename
+func writeKML(w io.Writer, waypoints []Waypoint, images map[string][]float64, imageBase string) error {
+	var doc kmlFile
+	doc.Xmlns = "http://www.opengis.net/kml/2.2"
+	doc.Document.Name = "Tour"
+
+	tracks := groupByRider(waypoints)
+	for _, rider := range slices.Sorted(maps.Keys(tracks)) {
+		coords := make([]string, 0, len(tracks[rider]))
+		for _, wp := range tracks[rider] {
+			coords = append(coords, kmlCoordinates(*wp.Location))
+		}
+
+		name := rider
+		if name == "" {
+			name = "Tour"
+		}
+		doc.Document.Placemarks = append(doc.Document.Placemarks, kmlPlacemark{
+			Name:       name,
+			LineString: &kmlLineString{Tessellate: 1, Coordinates: strings.Join(coords, " ")},
+		})
+	}
+
+	for _, filename := range slices.Sorted(maps.Keys(images)) {
+		coords := images[filename]
+		doc.Document.Placemarks = append(doc.Document.Placemarks, kmlPlacemark{
+			Name:        filename,
+			Description: imageBase + url.PathEscape(filename),
+			Point:       &kmlPoint{Coordinates: kmlCoordinates(GPSCoords{Latitude: coords[0], Longitude: coords[1]})},
+		})
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	return enc.Encode(doc)
+}
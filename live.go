@@ -0,0 +1,220 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Positions queued per client, slower clients are disconnected
+const liveQueueSize = 16
+
+// Time allowed to write a message to a client
+const liveWriteTimeout = 10 * time.Second
+
+var upgrader = websocket.Upgrader{}
+
+// Message pushed to live clients
+type LivePosition struct {
+	Rider     string    `json:"rider"`
+	Lat       float64   `json:"lat"`
+	Lng       float64   `json:"lng"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// Connected WebSocket or event stream client, restricted clients only get
+// positions outside the hidden area
+type liveClient struct {
+	send       chan LivePosition
+	restricted bool
+	rider      string
+	allRiders  bool
+}
+
+// Registry of connected live clients
+type liveHub struct {
+	mutex   sync.Mutex
+	clients map[*liveClient]struct{}
+	// Latest position sent to restricted clients per rider
+	restrictedLatest map[string]time.Time
+}
+
+func newLiveHub() *liveHub {
+	return &liveHub{
+		clients:          make(map[*liveClient]struct{}),
+		restrictedLatest: make(map[string]time.Time),
+	}
+}
+
+// Client for the requester, restricted unless it has a valid code
+func (app *App) newLiveClient(r *http.Request) *liveClient {
+	query := r.URL.Query()
+	return &liveClient{
+		send:       make(chan LivePosition, liveQueueSize),
+		restricted: !app.hasAccess(r),
+		rider:      query.Get("rider"),
+		allRiders:  !query.Has("rider"),
+	}
+}
+
+func (hub *liveHub) register(client *liveClient) {
+	hub.mutex.Lock()
+	defer hub.mutex.Unlock()
+
+	hub.clients[client] = struct{}{}
+}
+
+func (hub *liveHub) unregister(client *liveClient) {
+	hub.mutex.Lock()
+	defer hub.mutex.Unlock()
+
+	delete(hub.clients, client)
+}
+
+// Handle WebSocket connections receiving new positions as they arrive
+//
+// Query parameters:
+//
+//	rider  only positions of this rider are pushed
+func (app *App) handleLive(w http.ResponseWriter, r *http.Request) {
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// The upgrader already replied with an error
+		return
+	}
+	defer conn.Close()
+
+	client := app.newLiveClient(r)
+	app.live.register(client)
+	defer app.live.unregister(client)
+
+	// Clients don't send anything, reading only notices when they go away
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case <-closed:
+			return
+		case position, ok := <-client.send:
+			if !ok {
+				return
+			}
+
+			conn.SetWriteDeadline(time.Now().Add(liveWriteTimeout))
+			if err := conn.WriteJSON(position); err != nil {
+				slog.Debug("Error writing to live client", "error", err)
+				return
+			}
+		}
+	}
+}
+
+// Push a newly recorded waypoint to the live clients. Restricted clients get
+// the latest position outside the hidden area instead, and only if it moved.
+func (app *App) broadcastWaypoint(wp Waypoint) {
+	track := groupByRider(app.snapshotWaypoints())[wp.Rider]
+	visible := restrictWaypoints(track, app.config.Restriction, app.config.AnonRadiusKm)
+
+	precise := livePosition(wp)
+	var restricted *LivePosition
+
+	app.live.mutex.Lock()
+	defer app.live.mutex.Unlock()
+
+	if len(visible) > 0 {
+		latest := visible[len(visible)-1]
+		if !latest.Timestamp.Equal(app.live.restrictedLatest[wp.Rider]) {
+			app.live.restrictedLatest[wp.Rider] = latest.Timestamp
+			position := livePosition(latest)
+			restricted = &position
+		}
+	}
+
+	for client := range app.live.clients {
+		if !client.allRiders && client.rider != wp.Rider {
+			continue
+		}
+
+		position := precise
+		if client.restricted {
+			if restricted == nil {
+				continue
+			}
+			position = *restricted
+		}
+
+		select {
+		case client.send <- position:
+		default:
+			// Queue full, drop the client instead of blocking the scan
+			close(client.send)
+			delete(app.live.clients, client)
+		}
+	}
+}
+
+func livePosition(wp Waypoint) LivePosition {
+	return LivePosition{
+		Rider:     wp.Rider,
+		Lat:       wp.Location.Latitude,
+		Lng:       wp.Location.Longitude,
+		UpdatedAt: wp.Timestamp,
+	}
+}
+
+// Handle Server-Sent Events streams receiving new positions as they arrive
+//
+// Query parameters:
+//
+//	rider  only positions of this rider are sent
+func (app *App) handleStream(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	client := app.newLiveClient(r)
+	app.live.register(client)
+	defer app.live.unregister(client)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case position, ok := <-client.send:
+			if !ok {
+				return
+			}
+
+			data, err := json.Marshal(position)
+			if err != nil {
+				slog.Error("Error encoding live position", "error", err)
+				continue
+			}
+
+			if _, err := fmt.Fprintf(w, "data: %s\n\n", data); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
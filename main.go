@@ -1,13 +1,18 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
 	_ "embed"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"html/template"
 	"io"
 	"io/fs"
 	"log"
+	"log/slog"
 	"maps"
 	"math"
 	"net/http"
@@ -17,137 +22,265 @@ import (
 	"strings"
 	"sync"
 	"time"
+	"unicode"
 
+	"github.com/jdeng/goheif/heif"
 	"github.com/rwcarlsen/goexif/exif"
 )
 
-const dataDir = "./data"
-const imagesDir = "./images"
+const defaultDataDir = "./data"
+const defaultImagesDir = "./images"
 const trackingTokenFile = "./tracking_token.txt"
 const codesFile = "./codes.txt"
 
+// Override the files above when set, comma or newline separated
+const trackingTokenEnv = "TOURMAP_TRACKING_TOKEN"
+const codesEnv = "TOURMAP_CODES"
+
+// Comma-separated list of image extensions to scan, e.g. "jpg,jpeg"
+const imageExtensionsEnv = "TOURMAP_IMAGE_EXTENSIONS"
+
+var defaultImageExtensions = []string{".jpg", ".jpeg", ".tiff", ".tif", ".heic", ".heif", ".png", ".webp"}
+
 //go:embed index.html
 var tmpl string
 
+//go:embed favicon.svg
+var favicon []byte
+
+// Template read from disk on every request in dev mode
+const indexTemplateFile = "./index.html"
+
 // GPS coordinates structure
 type GPSCoords struct {
-	Latitude  float64 `json:"lat"`
-	Longitude float64 `json:"lng"`
+	Latitude  float64  `json:"lat"`
+	Longitude float64  `json:"lng"`
+	Elevation *float64 `json:"elevation,omitempty"`
+}
+
+// Location and capture time of an image
+type ImageLocation struct {
+	Coords GPSCoords
+	Taken  time.Time
 }
 
 // Karoo Live tracking entry
 type Waypoint struct {
 	Location  *GPSCoords `json:"location,omitempty"`
 	Timestamp time.Time  `json:"updatedAt"`
+	IngestID  string     `json:"ingestId,omitempty"`
+	Rider     string     `json:"rider,omitempty"`
+	HeartRate *int       `json:"heartRate,omitempty"`
+	Cadence   *int       `json:"cadence,omitempty"`
 }
 
 // Application state
 type App struct {
-	latestWaypoint *time.Time
+	config         *Config
+	latestByRider  map[string]time.Time
 	waypoints      []Waypoint
-	imageLocations map[string]GPSCoords
+	plannedRoutes  [][]Waypoint
+	imageLocations map[string]ImageLocation
 	wpMutex        sync.RWMutex
 	imagesMutex    sync.RWMutex
 	codesMutex     sync.RWMutex
-	codes          map[string]struct{}
+	codes          map[string]time.Time
+	timezones      *TimezoneResolver
+	seenIDs        *idSet
+	trackFiles     map[string]*trackImport
+	trackMutex     sync.Mutex
+	persistMutex   sync.Mutex
+	thumbsMutex    sync.Mutex
+	persistFailing bool
+	index          *template.Template
+	client         *http.Client
+	geocoder       *Geocoder
+	live           *liveHub
+	cookieSecret   []byte
+
+	// Waypoint per rider rejected as too fast to reach, until the next one
+	// shows whether it was a spike, guarded by wpMutex
+	spikes map[string]Waypoint
+
+	// Marshaled page data, reset whenever the underlying data changes
+	tracksJSON           []byte
+	restrictedTracksJSON []byte
+	imagesJSON           []byte
+	// Track lines drawn into tiles, reset along with the marshaled tracks
+	tileLines           []tileLine
+	restrictedTileLines []tileLine
+	// When the page data last changed, guarded by wpMutex and imagesMutex
+	tracksModified time.Time
+	imagesModified time.Time
 }
 
 func main() {
+	config, err := loadConfig(os.Args[1:])
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: config.LogLevel})))
+
 	app := &App{
+		config:         config,
 		waypoints:      make([]Waypoint, 0),
-		imageLocations: make(map[string]GPSCoords),
-		codes:          make(map[string]struct{}),
+		latestByRider:  make(map[string]time.Time),
+		spikes:         make(map[string]Waypoint),
+		imageLocations: make(map[string]ImageLocation),
+		codes:          make(map[string]time.Time),
+		timezones:      newTimezoneResolver(config.TimeZone, config.DetectTimeZone),
+		seenIDs:        newIDSet(maxSeenIDs),
+		trackFiles:     make(map[string]*trackImport),
+		client:         &http.Client{Timeout: config.FetchTimeout},
+		live:           newLiveHub(),
+		cookieSecret:   loadCookieSecret(os.Getenv(cookieSecretEnv)),
+	}
+	app.geocoder = newGeocoder(config.GeocodeURL, app.client)
+
+	app.index, err = template.New("index").Parse(tmpl)
+	if err != nil {
+		slog.Error("Error parsing index template", "error", err)
+		os.Exit(1)
 	}
 
 	// Create data dir if not exists
-	os.MkdirAll(dataDir, 0755)
+	if err := os.MkdirAll(config.DataDir, 0755); err != nil {
+		slog.Error("Error creating data directory", "path", config.DataDir, "error", err)
+	}
 
 	// Initial data load
+	app.loadCodes()
 	app.loadWaypoints()
 	app.scanImages()
 
 	// Start periodic updates
-	go app.periodicImageScan()
 	go app.periodicWaypointScan()
+	app.watchDirectories()
 
 	// Setup HTTP server
 	app.setupHTTPServer()
 
 	// Start server
-	fmt.Println("Server starting on :8080")
-	log.Fatal(http.ListenAndServe(":8080", nil))
+	err = config.listenAndServe(logRequests(http.DefaultServeMux))
+	slog.Error("Server stopped", "error", err)
+	os.Exit(1)
 }
 
-// Load all JSON files from /data directory
+// Load all JSON files from /data directory and imported track files.
+// GeoJSON files in /data and the route directory are loaded as planned
+// routes.
 func (app *App) loadWaypoints() {
 	nextPathData := make([]Waypoint, 0)
+	routes := make([][]Waypoint, 0)
+	skipped := 0
 
-	err := filepath.WalkDir(dataDir, func(path string, d fs.DirEntry, err error) error {
+	err := filepath.WalkDir(app.config.DataDir, func(path string, d fs.DirEntry, err error) error {
 		if err != nil {
 			return err
 		}
 
+		if !d.IsDir() && strings.HasSuffix(strings.ToLower(path), ".geojson") {
+			route, err := parseGeoJsonFile(path)
+			if err != nil {
+				slog.Error("Error parsing GeoJSON file", "path", path, "error", err)
+				skipped++
+				return nil
+			}
+			if len(route) > 0 {
+				routes = append(routes, route)
+			}
+			return nil
+		}
+
 		if !d.IsDir() && strings.HasSuffix(strings.ToLower(path), ".json") {
 			data, err := os.ReadFile(path)
 			if err != nil {
-				log.Printf("Error reading JSON file %s: %v", path, err)
+				slog.Error("Error reading JSON file", "path", path, "error", err)
+				skipped++
 				return nil
 			}
 
 			var wp Waypoint
 			if err := json.Unmarshal(data, &wp); err != nil {
-				log.Printf("Error parsing JSON file %s: %v", path, err)
+				slog.Error("Error parsing JSON file", "path", path, "error", err)
+				skipped++
 				return nil
 			}
 
-			if wp.Location != nil {
-				nextPathData = append(nextPathData, wp)
+			if wp.Location == nil {
+				return nil
 			}
+			// Keep all timestamps in UTC, so file names and responses
+			// don't depend on the offset a provider sent
+			wp.Timestamp = wp.Timestamp.UTC()
+
+			if !validCoords(*wp.Location) {
+				slog.Warn("Skipping waypoint with invalid coordinates", "path", path, "lat", wp.Location.Latitude, "lng", wp.Location.Longitude)
+				skipped++
+				return nil
+			}
+
+			nextPathData = append(nextPathData, wp)
 		}
 
 		return nil
 	})
 
 	if err != nil {
-		log.Printf("Error walking data directory: %v", err)
+		slog.Error("Error walking data directory", "path", app.config.DataDir, "error", err)
 	}
 
-	slices.SortFunc(nextPathData, func(a, b Waypoint) int {
-		return a.Timestamp.Compare(b.Timestamp)
-	})
+	slog.Info("Loaded JSON files", "path", app.config.DataDir, "waypoint_count", len(nextPathData), "route_count", len(routes), "skipped_count", skipped)
+
+	nextPathData = app.config.smoothTracks(nextPathData)
 
-	if len(nextPathData) > 0 {
-		latest := nextPathData[len(nextPathData)-1].Timestamp
-		app.latestWaypoint = &latest
+	app.loadTrackImports()
+	imported := make([]Waypoint, 0)
+	for _, track := range app.importedTracks() {
+		imported = append(imported, track...)
 	}
+	nextPathData = mergeWaypoints(nextPathData, imported, app.config.reduceTrack)
+	nextPathData = capWaypoints(nextPathData, app.config.MaxWaypoints)
 
-	log.Printf("Loaded %d JSON files", len(nextPathData))
+	latestByRider := make(map[string]time.Time)
+	for _, wp := range nextPathData {
+		latestByRider[wp.Rider] = wp.Timestamp
+	}
 
 	app.wpMutex.Lock()
 	defer app.wpMutex.Unlock()
 
 	app.waypoints = nextPathData
+	app.plannedRoutes = append(routes, loadRouteFiles(app.config.RouteDir)...)
+	app.latestByRider = latestByRider
+	app.resetTracksJSON()
+	for _, wp := range nextPathData {
+		if wp.IngestID != "" {
+			app.seenIDs.add(wp.IngestID)
+		}
+	}
 }
 
 // Scan images directory for GPS coordinates
 func (app *App) scanImages() {
-	newGPSData := make(map[string]GPSCoords)
+	newGPSData := make(map[string]ImageLocation)
 
-	err := filepath.WalkDir(imagesDir, func(path string, d fs.DirEntry, err error) error {
+	err := filepath.WalkDir(app.config.ImagesDir, func(path string, d fs.DirEntry, err error) error {
 		if err != nil {
 			return err
 		}
 
 		if !d.IsDir() && app.isImageFile(path) {
-			coords, err := app.extractGPSCoords(path)
+			location, err := app.extractGPSCoords(path)
 			if err != nil {
-				log.Printf("Error extracting GPS from %s: %v", filepath.Base(path), err)
+				slog.Warn("Error extracting GPS", "path", path, "error", err)
 				return nil
 			}
 
-			if coords != nil {
+			if location != nil {
 				filename := filepath.Base(path)
-				newGPSData[filename] = *coords
+				newGPSData[filename] = *location
 			}
 		}
 
@@ -155,7 +288,7 @@ func (app *App) scanImages() {
 	})
 
 	if err != nil {
-		log.Printf("Error walking images directory: %v", err)
+		slog.Error("Error walking images directory", "path", app.config.ImagesDir, "error", err)
 		return
 	}
 
@@ -163,24 +296,65 @@ func (app *App) scanImages() {
 	defer app.imagesMutex.Unlock()
 
 	app.imageLocations = newGPSData
+	app.imagesJSON = nil
+	app.imagesModified = time.Now()
+}
+
+// Parse a comma-separated extension list, falling back to the defaults
+func parseImageExtensions(list string) map[string]struct{} {
+	exts := make(map[string]struct{})
+	for _, ext := range strings.Split(list, ",") {
+		ext = strings.ToLower(strings.TrimSpace(ext))
+		ext = strings.TrimLeft(ext, ".")
+		if ext != "" {
+			exts["."+ext] = struct{}{}
+		}
+	}
+
+	if len(exts) == 0 {
+		for _, ext := range defaultImageExtensions {
+			exts[ext] = struct{}{}
+		}
+	}
+
+	return exts
 }
 
 // Check if file is an image
 func (app *App) isImageFile(filename string) bool {
-	ext := strings.ToLower(filepath.Ext(filename))
-	return ext == ".jpg" || ext == ".jpeg" || ext == ".tiff" || ext == ".tif"
+	_, ok := app.config.ImageExts[strings.ToLower(filepath.Ext(filename))]
+	return ok
 }
 
-// Extract GPS coordinates from image EXIF data
-func (app *App) extractGPSCoords(imagePath string) (*GPSCoords, error) {
+// Extract GPS coordinates and capture time from image EXIF data
+func (app *App) extractGPSCoords(imagePath string) (*ImageLocation, error) {
 	file, err := os.Open(imagePath)
 	if err != nil {
 		return nil, err
 	}
 	defer file.Close()
 
+	// HEIF, PNG and WebP containers store EXIF in a separate item or chunk
+	// which has to be located first, other formats can be decoded directly
+	var exifData io.Reader = file
+	var raw []byte
+	switch strings.ToLower(filepath.Ext(imagePath)) {
+	case ".heic", ".heif":
+		raw, err = heif.Open(file).EXIF()
+	case ".png":
+		raw, err = pngEXIF(file)
+	case ".webp":
+		raw, err = webpEXIF(file)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if raw != nil {
+		exifData = bytes.NewReader(raw)
+	}
+
 	// Decode EXIF data
-	x, err := exif.Decode(file)
+	x, err := exif.Decode(exifData)
 	if err != nil {
 		return nil, err // No EXIF data or corrupted
 	}
@@ -191,15 +365,24 @@ func (app *App) extractGPSCoords(imagePath string) (*GPSCoords, error) {
 		return nil, err // No GPS data
 	}
 
-	return &GPSCoords{
-		Latitude:  lat,
-		Longitude: lon,
+	// Capture time is optional, images without it are kept
+	taken, err := x.DateTime()
+	if err != nil {
+		taken = time.Time{}
+	}
+
+	return &ImageLocation{
+		Coords: GPSCoords{
+			Latitude:  lat,
+			Longitude: lon,
+		},
+		Taken: taken,
 	}, nil
 }
 
 // Periodic image scanning
 func (app *App) periodicImageScan() {
-	ticker := time.NewTicker(300 * time.Second)
+	ticker := time.NewTicker(app.config.ImageInterval)
 	defer ticker.Stop()
 
 	for range ticker.C {
@@ -207,117 +390,200 @@ func (app *App) periodicImageScan() {
 	}
 }
 
-// Periodic image scanning
+// Replace the access codes with the ones in TOURMAP_CODES or the codes file,
+// so removed lines revoke access. A missing file means there are no codes.
+func (app *App) loadCodes() {
+	codes := make(map[string]time.Time)
+	if value := os.Getenv(codesEnv); value != "" {
+		codes = parseCodes(splitCodeList(value))
+	} else if data, err := os.ReadFile(codesFile); err == nil {
+		codes = parseCodes(string(data))
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		slog.Error("Error reading codes file", "path", codesFile, "error", err)
+		return
+	}
+
+	app.codesMutex.Lock()
+	defer app.codesMutex.Unlock()
+
+	app.codes = codes
+}
+
+// Periodic waypoint scanning
 func (app *App) periodicWaypointScan() {
-	tokenDeleted := false
-	lastToken := ""
-	ticker := time.NewTicker(15 * time.Second)
+	tokens := newTokenState()
+	ticker := time.NewTicker(app.config.TrackInterval)
 	defer ticker.Stop()
 
 	for range ticker.C {
-		{
-			data, err := os.ReadFile(codesFile)
-			if err != nil {
-				log.Printf("Error reading codes file %s: %v", codesFile, err)
-			} else {
-				codes := strings.TrimSpace(string(data))
-				if codes != "" {
-					newCodes := strings.Split(codes, "\n")
-					app.codesMutex.Lock()
-					if app.codes == nil {
-						app.codes = make(map[string]struct{})
-					}
-					for _, code := range newCodes {
-						code = strings.TrimSpace(code)
-						if code != "" {
-							app.codes[code] = struct{}{}
-						}
-					}
-					app.codesMutex.Unlock()
-				}
+		app.loadCodes()
+
+		// Call http endpoint for every token defined in TOURMAP_TRACKING_TOKEN
+		// or tracking_token.txt
+		for _, source := range tokens.update(readTrackingTokens()) {
+			wp, err := fetchWaypointWithRetry(context.Background(), app.trackingProvider(source.Token))
+			if errors.Is(err, errTokenNotFound) {
+				slog.Warn("Tracking token not found, stopping further requests", "rider", source.Rider, "token_hash", tokenHash(source.Token))
+				tokens.markDeleted(source.Token)
+				continue
+			} else if err != nil {
+				slog.Error("Error fetching tracking data", "rider", source.Rider, "status", fetchStatus(err), "error", err)
+				continue
 			}
-		}
 
-		// Call http endpoint defined in tracking_token.txt
-		data, err := os.ReadFile(trackingTokenFile)
-		if err != nil {
-			log.Printf("Error reading tracking token file %s: %v", trackingTokenFile, err)
-			continue
-		}
+			if wp.Location == nil {
+				continue
+			}
 
-		token := strings.TrimSpace(string(data))
-		if token != lastToken {
-			log.Printf("Using new tracking token: %s", token)
-			lastToken = token
-			tokenDeleted = false
-		} else if tokenDeleted {
-			continue
-		} else if token == "" {
-			log.Printf("Tracking token file %s is empty", trackingTokenFile)
-			lastToken = token
-			tokenDeleted = true
-			continue
+			wp.Rider = source.Rider
+			if app.repeatedPosition(wp) {
+				continue
+			}
+			app.recordWaypoint(wp)
 		}
+	}
+}
 
-		processedURL := fmt.Sprintf("https://dashboard.hammerhead.io/v1/shares/tracking/%s", token)
-		resp, err := http.Get(processedURL)
-		if err != nil {
-			log.Printf("Error fetching tracking data: %v", err)
-			continue
-		}
+// Whether a tracked waypoint repeats the rider's last recorded position.
+// Stationary devices keep reporting the same position with a new timestamp,
+// which is only recorded again once MinInterval passed. Compared to the
+// recorded waypoints, so the first poll after a restart isn't recorded twice.
+func (app *App) repeatedPosition(wp Waypoint) bool {
+	app.wpMutex.RLock()
+	last, ok := app.lastWaypoint(wp.Rider)
+	app.wpMutex.RUnlock()
+	if !ok || wp.Timestamp.Sub(last.Timestamp) >= app.config.MinInterval {
+		return false
+	}
 
-		if resp.StatusCode == http.StatusNotFound {
-			log.Printf("Tracking token %s not found, stopping further requests", token)
-			tokenDeleted = true
-			resp.Body.Close()
-			continue
-		} else if resp.StatusCode != http.StatusOK {
-			log.Printf("Non-OK HTTP status: %s", resp.Status)
-			resp.Body.Close()
-			continue
-		}
+	a, b := last.Location, wp.Location
+	return distanceKm(a.Latitude, a.Longitude, b.Latitude, b.Longitude) <= app.config.PruneDistanceKm
+}
 
-		// Read as string
-		dataRaw, err := io.ReadAll(resp.Body)
-		if err != nil {
-			log.Printf("Error reading tracking response body: %v", err)
-			resp.Body.Close()
-			continue
+// Append a waypoint newer than the latest one of its rider and persist it
+// to the data directory. Waypoints with an already seen ingest id or too
+// close in time and space to the previous one are ignored.
+//
+// A waypoint too fast to reach from the previous one is held back as a GPS
+// spike. If the next waypoint can only be reached from the held back one
+// instead, the rider really moved there and the track continues.
+func (app *App) recordWaypoint(wp Waypoint) bool {
+	app.wpMutex.Lock()
+	if wp.IngestID != "" && app.seenIDs.contains(wp.IngestID) {
+		app.wpMutex.Unlock()
+		return false
+	}
+	if latest, ok := app.latestByRider[wp.Rider]; ok && !wp.Timestamp.After(latest) {
+		app.wpMutex.Unlock()
+		return false
+	}
+	last, hasLast := app.lastWaypoint(wp.Rider)
+	if hasLast && !app.config.significantChange(last, wp) {
+		app.wpMutex.Unlock()
+		return false
+	}
+	if maxSpeed := app.config.MaxSpeedKmh; hasLast && maxSpeed > 0 && speedKmh(last, wp) > maxSpeed {
+		if spike, ok := app.spikes[wp.Rider]; !ok || speedKmh(spike, wp) > maxSpeed {
+			app.spikes[wp.Rider] = wp
+			app.wpMutex.Unlock()
+			slog.Debug("Holding back waypoint too fast to reach", "rider", wp.Rider, "speed_kmh", speedKmh(last, wp))
+			return false
 		}
+	}
+	delete(app.spikes, wp.Rider)
+	app.waypoints = capWaypoints(append(app.waypoints, wp), app.config.MaxWaypoints)
+	app.latestByRider[wp.Rider] = wp.Timestamp
+	app.resetTracksJSON()
+	if wp.IngestID != "" {
+		app.seenIDs.add(wp.IngestID)
+	}
+	app.wpMutex.Unlock()
 
-		var fetchedWaypoints Waypoint
-		if err := json.Unmarshal(dataRaw, &fetchedWaypoints); err != nil {
-			log.Printf("Error decoding tracking JSON: %v", err)
-			resp.Body.Close()
-			continue
-		}
-		resp.Body.Close()
+	app.broadcastWaypoint(wp)
 
-		if fetchedWaypoints.Location != nil {
-			app.wpMutex.Lock()
-			if app.latestWaypoint == nil || fetchedWaypoints.Timestamp.After(*app.latestWaypoint) {
-				app.waypoints = append(app.waypoints, fetchedWaypoints)
-				app.latestWaypoint = &fetchedWaypoints.Timestamp
-			}
-			app.wpMutex.Unlock()
+	// Store our own encoding rather than the provider response, so every
+	// provider's waypoints load the same way and keep their rider
+	raw, err := json.Marshal(wp)
+	if err != nil {
+		slog.Error("Error encoding waypoint", "error", err)
+		return true
+	}
 
-			filename := fmt.Sprintf("%s/tracking_%s.json", dataDir, fetchedWaypoints.Timestamp.Format("20060102_150405"))
-			os.WriteFile(filename, dataRaw, 0644)
+	app.persistMutex.Lock()
+	defer app.persistMutex.Unlock()
+
+	// The content hash keeps waypoints sharing a second apart, while the
+	// exact same waypoint is only written once
+	sum := sha256.Sum256(raw)
+	name := fmt.Sprintf("tracking_%s_%x.json", wp.Timestamp.Format("20060102_150405"), sum[:4])
+	if wp.Rider != "" {
+		name = fmt.Sprintf("tracking_%s_%s_%x.json", safeFilename(wp.Rider), wp.Timestamp.Format("20060102_150405"), sum[:4])
+	}
+	filename := filepath.Join(app.config.DataDir, name)
+	if _, err := os.Stat(filename); err == nil {
+		return true
+	}
+	// Keep the waypoint in memory even if it cannot be persisted
+	err = writeFileAtomic(filename, func(w io.Writer) error {
+		_, err := w.Write(raw)
+		return err
+	})
+	if err != nil {
+		if !app.persistFailing {
+			slog.Error("Error writing waypoint, keeping waypoints in memory only", "path", filename, "error", err)
+			app.persistFailing = true
 		}
+	} else if app.persistFailing {
+		slog.Info("Writing waypoints succeeded again", "path", app.config.DataDir)
+		app.persistFailing = false
 	}
+
+	return true
 }
 
 // Setup HTTP server routes
 func (app *App) setupHTTPServer() {
-	// Serve static files from /images with cache control headers
-	imageHandler := http.StripPrefix("/images/", http.FileServer(http.Dir(imagesDir)))
-	http.Handle("/images/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Cache-Control", "public, max-age=259200")
-		imageHandler.ServeHTTP(w, r)
-	}))
+	http.HandleFunc("/images/", app.handleImageFile)
+
+	http.HandleFunc("/api/updates", app.cors(gzipHandler(app.handleUpdates)))
+	http.HandleFunc("POST /api/ingest", app.cors(app.handleIngest))
+	http.HandleFunc("OPTIONS /api/ingest", app.cors(http.NotFound))
+	http.HandleFunc("POST /api/upload", app.cors(app.handleUpload))
+	http.HandleFunc("OPTIONS /api/upload", app.cors(http.NotFound))
+	http.HandleFunc("POST /api/owntracks", app.handleOwnTracks)
+	http.HandleFunc("/api/osmand", app.handleOsmAnd)
+	http.HandleFunc("/api/bounds", app.cors(app.handleBounds))
+	http.HandleFunc("/api/latest", app.cors(app.handleLatest))
+	http.HandleFunc("/api/config", app.cors(app.handleConfig))
+	http.HandleFunc("/api/routes", app.cors(gzipHandler(app.handleRoutes)))
+	http.HandleFunc("/api/stats", app.cors(gzipHandler(app.handleStats)))
+	http.HandleFunc("/api/checkpoints", app.cors(app.handleCheckpoints))
+	http.HandleFunc("/api/images", app.cors(gzipHandler(app.handleImages)))
+	http.HandleFunc("/api/location", app.cors(app.handleLocation))
+	http.HandleFunc("/ws", app.handleLive)
+	http.HandleFunc("/api/stream", app.cors(app.handleStream))
+	http.HandleFunc("/api/playback", app.cors(gzipHandler(app.handlePlayback)))
+	http.HandleFunc("/api/track.geojson", app.cors(gzipHandler(app.handleGeoJSON)))
+	http.HandleFunc("/api/track.gpx", app.cors(gzipHandler(app.handleGPX)))
+	http.HandleFunc("/api/track.kml", app.cors(gzipHandler(app.handleKML)))
+	http.HandleFunc("/tiles/{z}/{x}/{y}", app.handleTile)
+
+	http.HandleFunc("/thumbs/{name}", app.handleThumb)
+
+	http.HandleFunc("/login", app.handleLogin)
+	http.HandleFunc("/favicon.ico", handleFavicon)
+	http.HandleFunc("/favicon.svg", handleFavicon)
+
+	// Main index page, any other path is not found
+	http.HandleFunc("/{$}", app.handleIndex)
+	http.HandleFunc("/index.html", app.handleIndex)
+}
 
-	// Main index page
-	http.HandleFunc("/", app.handleIndex)
+// Handle the favicon, browsers accept SVG also under /favicon.ico
+func handleFavicon(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "image/svg+xml")
+	w.Header().Set("Cache-Control", "public, max-age=259200")
+	w.Write(favicon)
 }
 
 func distanceKm(lat1, lon1, lat2, lon2 float64) float64 {
@@ -339,65 +605,364 @@ func distanceKm(lat1, lon1, lat2, lon2 float64) float64 {
 	return R * c
 }
 
-// Handle main index page
-func (app *App) handleIndex(w http.ResponseWriter, r *http.Request) {
+// Equirectangular approximation of distanceKm, several times cheaper. For
+// the few hundred meters between neighboring waypoints it is off by far less
+// than a meter, but the error grows with distance and towards the poles, so
+// it is only meant for threshold checks between nearby points.
+func distanceKmFast(lat1, lon1, lat2, lon2 float64) float64 {
+	const R = 6371.0
+
+	dLon := math.Remainder(lon2-lon1, 360) * math.Pi / 180
+	dLat := (lat2 - lat1) * math.Pi / 180
+	x := dLon * math.Cos((lat1+lat2)/2*math.Pi/180)
+
+	return R * math.Sqrt(x*x+dLat*dLat)
+}
+
+// Check whether the request carries a known access code
+func (app *App) hasAccess(r *http.Request) bool {
+	return slices.ContainsFunc(app.requestCodes(r), app.validCode)
+}
+
+// Check whether the code is a known and not expired access code, listed
+// either in plain or as hash
+func (app *App) validCode(code string) bool {
+	if code == "" {
+		return false
+	}
+
+	app.codesMutex.RLock()
+	defer app.codesMutex.RUnlock()
+
+	// The hash itself must not grant access
+	expiry, exists := app.codes[code]
+	if !exists || strings.HasPrefix(code, hashedCodePrefix) {
+		expiry, exists = app.codes[hashedCodeKey(code)]
+	}
+
+	return exists && (expiry.IsZero() || time.Now().Before(expiry))
+}
+
+// Latest waypoint of the rider, must be called with wpMutex held
+func (app *App) lastWaypoint(rider string) (Waypoint, bool) {
+	for i := len(app.waypoints) - 1; i >= 0; i-- {
+		if app.waypoints[i].Rider == rider {
+			return app.waypoints[i], true
+		}
+	}
+
+	return Waypoint{}, false
+}
+
+// Copy of all waypoints. Readers filter and marshal the copy without holding
+// wpMutex.
+func (app *App) snapshotWaypoints() []Waypoint {
+	app.wpMutex.RLock()
+	defer app.wpMutex.RUnlock()
+
+	return slices.Clone(app.waypoints)
+}
+
+// Waypoints the requester is allowed to see
+func (app *App) visibleWaypoints(r *http.Request) []Waypoint {
+	waypoints := app.snapshotWaypoints()
+
+	query := r.URL.Query()
+	if query.Has("rider") {
+		rider := query.Get("rider")
+		waypoints = slices.DeleteFunc(waypoints, func(wp Waypoint) bool {
+			return wp.Rider != rider
+		})
+	}
+
+	if !app.hasAccess(r) {
+		waypoints = restrictRiders(waypoints, app.config.Restriction, app.config.AnonRadiusKm)
+	}
+
+	return waypoints
+}
+
+// Replace everything but letters, digits, dashes and underscores
+func safeFilename(name string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || r == '-' || r == '_' {
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+// Split waypoints into one track per rider, keeping their order
+func groupByRider(waypoints []Waypoint) map[string][]Waypoint {
+	tracks := make(map[string][]Waypoint)
+	for _, wp := range waypoints {
+		tracks[wp.Rider] = append(tracks[wp.Rider], wp)
+	}
+
+	return tracks
+}
+
+// Apply fn to the track of every rider separately and merge the results
+// back into time order
+func mapRiders(waypoints []Waypoint, fn func([]Waypoint) []Waypoint) []Waypoint {
+	tracks := groupByRider(waypoints)
+	if len(tracks) <= 1 {
+		return fn(waypoints)
+	}
+
+	merged := make([]Waypoint, 0, len(waypoints))
+	for _, track := range tracks {
+		merged = append(merged, fn(track)...)
+	}
+
+	slices.SortStableFunc(merged, func(a, b Waypoint) int {
+		return a.Timestamp.Compare(b.Timestamp)
+	})
+
+	return merged
+}
+
+// Convert waypoints to [lat, lng] pairs for the frontend
+func waypointCoords(waypoints []Waypoint) [][]float64 {
+	coords := make([][]float64, 0, len(waypoints))
+	for _, wp := range waypoints {
+		coords = append(coords, []float64{wp.Location.Latitude, wp.Location.Longitude})
+	}
+
+	return coords
+}
+
+// Tracks as [lat, lng] pairs keyed by rider
+func riderTracks(waypoints []Waypoint) map[string][][]float64 {
+	tracks := make(map[string][][]float64)
+	for rider, track := range groupByRider(waypoints) {
+		tracks[rider] = waypointCoords(track)
+	}
+
+	return tracks
+}
+
+// Drop the marshaled tracks and tile lines, must be called with wpMutex held
+func (app *App) resetTracksJSON() {
+	app.tracksJSON = nil
+	app.restrictedTracksJSON = nil
+	app.tileLines = nil
+	app.restrictedTileLines = nil
+	app.tracksModified = time.Now()
+}
+
+// Time the index page content last changed
+func (app *App) pageModified() time.Time {
+	app.wpMutex.RLock()
+	modified := app.tracksModified
+	app.wpMutex.RUnlock()
+
 	app.imagesMutex.RLock()
-	images := make(map[string]GPSCoords)
-	maps.Copy(images, app.imageLocations)
-	app.imagesMutex.RUnlock()
-	
+	defer app.imagesMutex.RUnlock()
+
+	if app.imagesModified.After(modified) {
+		return app.imagesModified
+	}
+	return modified
+}
+
+// Marshaled track segments of all riders, restricted for anonymous viewers. The
+// result is kept until the waypoints change.
+func (app *App) cachedTracksJSON(restricted bool) ([]byte, error) {
 	app.wpMutex.RLock()
-	waypoints := make([][]float64, 0, len(app.waypoints))
-	for _, wp := range app.waypoints {
-		waypoints = append(waypoints, []float64{wp.Location.Latitude, wp.Location.Longitude})
+	cached := app.tracksJSON
+	if restricted {
+		cached = app.restrictedTracksJSON
 	}
+	modified := app.tracksModified
 	app.wpMutex.RUnlock()
+	if cached != nil {
+		return cached, nil
+	}
 
-	code := r.URL.Query().Get("code")
-	app.codesMutex.RLock()
-	if _, exists := app.codes[code]; !exists && len(waypoints) > 0 {
-		lastWaypoint := waypoints[len(waypoints)-1]
-		i := len(waypoints) - 1
-		for ; i >= 0; i-- {
-			// if distance is more than 1km, break
-			if distanceKm(lastWaypoint[0], lastWaypoint[1], waypoints[i][0], waypoints[i][1]) > 10.0 {
-				break
-			}
+	waypoints := app.snapshotWaypoints()
+	if restricted {
+		waypoints = restrictRiders(waypoints, app.config.Restriction, app.config.AnonRadiusKm)
+	}
+
+	data, err := json.Marshal(riderSegments(waypoints, app.config.SegmentGap))
+	if err != nil {
+		return nil, err
+	}
+
+	app.wpMutex.Lock()
+	defer app.wpMutex.Unlock()
+
+	// Don't cache tracks that changed while marshaling
+	if !app.tracksModified.Equal(modified) {
+		return data, nil
+	}
+	if restricted {
+		app.restrictedTracksJSON = data
+	} else {
+		app.tracksJSON = data
+	}
+	return data, nil
+}
+
+// Marshaled image locations, kept until the next image scan
+func (app *App) cachedImagesJSON() ([]byte, error) {
+	app.imagesMutex.RLock()
+	cached := app.imagesJSON
+	modified := app.imagesModified
+	app.imagesMutex.RUnlock()
+	if cached != nil {
+		return cached, nil
+	}
+
+	data, err := json.Marshal(imageCoords(app.snapshotImages(), time.Time{}, time.Time{}))
+	if err != nil {
+		return nil, err
+	}
+
+	app.imagesMutex.Lock()
+	defer app.imagesMutex.Unlock()
+
+	// Don't cache images that changed while marshaling
+	if app.imagesModified.Equal(modified) {
+		app.imagesJSON = data
+	}
+	return data, nil
+}
+
+// Copy of the image locations keyed by filename. Readers go through this
+// instead of accessing imageLocations, which scanImages replaces.
+func (app *App) snapshotImages() map[string]ImageLocation {
+	app.imagesMutex.RLock()
+	defer app.imagesMutex.RUnlock()
+
+	return maps.Clone(app.imageLocations)
+}
+
+// Image locations the requester is allowed to see. Viewers without a code
+// don't get the images in the hidden area around the latest position.
+func (app *App) visibleImages(r *http.Request) map[string]ImageLocation {
+	images := app.snapshotImages()
+	if app.hasAccess(r) {
+		return images
+	}
+
+	return restrictImages(images, app.snapshotWaypoints(), app.config.Restriction, app.config.AnonRadiusKm)
+}
+
+// Image locations as [lat, lng] pairs keyed by filename. If since or until
+// is set only images taken after since and up to until are included, images
+// without a capture time are then left out.
+func imageCoords(images map[string]ImageLocation, since, until time.Time) map[string][]float64 {
+	imageData := make(map[string][]float64, len(images))
+	for filename, image := range images {
+		if !since.IsZero() && !image.Taken.After(since) {
+			continue
+		}
+		if !until.IsZero() && (image.Taken.IsZero() || image.Taken.After(until)) {
+			continue
 		}
+		imageData[filename] = []float64{image.Coords.Latitude, image.Coords.Longitude}
+	}
 
-		waypoints = waypoints[:i+1]
+	return imageData
+}
+
+// Template of the index page, re-read from disk in dev mode so edits show
+// up without a rebuild
+func (app *App) indexTemplate() (*template.Template, error) {
+	if !app.config.Dev {
+		return app.index, nil
 	}
-	app.codesMutex.RUnlock()
 
-	t, err := template.New("index").Parse(tmpl)
+	data, err := os.ReadFile(indexTemplateFile)
 	if err != nil {
+		return nil, err
+	}
+
+	return template.New("index").Parse(string(data))
+}
+
+// Handle main index page
+func (app *App) handleIndex(w http.ResponseWriter, r *http.Request) {
+	// Keep the code out of the browser history and referrers
+	if app.stripCode(w, r) {
+		return
+	}
+
+	// The page depends on the access code, which may come from a cookie
+	w.Header().Set("Vary", "Cookie, X-Access-Code")
+	if !app.config.Dev {
+		modified := app.pageModified()
+		w.Header().Set("Last-Modified", modified.UTC().Format(http.TimeFormat))
+		if since, err := http.ParseTime(r.Header.Get("If-Modified-Since")); err == nil && !modified.Truncate(time.Second).After(since) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+
+	t, err := app.indexTemplate()
+	if err != nil {
+		slog.Error("Error loading index template", "path", indexTemplateFile, "error", err)
 		http.Error(w, "Template error", http.StatusInternalServerError)
 		return
 	}
 
-	imageData := make(map[string][]float64)
-	for filename, coords := range images {
-		imageData[filename] = []float64{coords.Latitude, coords.Longitude}
+	var imageDataJson []byte
+	if app.hasAccess(r) {
+		imageDataJson, err = app.cachedImagesJSON()
+	} else {
+		imageDataJson, err = json.Marshal(imageCoords(app.visibleImages(r), time.Time{}, time.Time{}))
+	}
+	if err != nil {
+		http.Error(w, "JSON encoding error", http.StatusInternalServerError)
+		return
 	}
 
-	imageDataJson, err := json.Marshal(imageData)
+	var tracksJson []byte
+	if r.URL.Query().Has("rider") {
+		tracksJson, err = json.Marshal(riderSegments(app.visibleWaypoints(r), app.config.SegmentGap))
+	} else {
+		tracksJson, err = app.cachedTracksJSON(!app.hasAccess(r))
+	}
 	if err != nil {
 		http.Error(w, "JSON encoding error", http.StatusInternalServerError)
 		return
 	}
 
-	waypointsJson, err := json.Marshal(waypoints)
+	paletteJson, err := json.Marshal(app.config.Palette)
 	if err != nil {
 		http.Error(w, "JSON encoding error", http.StatusInternalServerError)
 		return
 	}
 
+	// Without a configured center the map fits the track, the latest
+	// waypoint is only the view until then
+	center := app.config.Center
+	if center == nil {
+		if waypoints := app.visibleWaypoints(r); len(waypoints) > 0 {
+			center = waypoints[len(waypoints)-1].Location
+		}
+	}
+	centerJson := []byte("null")
+	if center != nil {
+		centerJson, _ = json.Marshal([]float64{center.Latitude, center.Longitude})
+	}
+
 	data := struct {
-		Images    template.JS
-		Waypoints template.JS
+		Images   template.JS
+		Tracks   template.JS
+		Palette  template.JS
+		Center   template.JS
+		Zoom     int
+		FitTrack bool
 	}{
-		Images:    template.JS(string(imageDataJson)),
-		Waypoints: template.JS(string(waypointsJson)),
+		Images:   template.JS(string(imageDataJson)),
+		Tracks:   template.JS(string(tracksJson)),
+		Palette:  template.JS(string(paletteJson)),
+		Center:   template.JS(string(centerJson)),
+		Zoom:     app.config.Zoom,
+		FitTrack: app.config.Center == nil,
 	}
 
 	w.Header().Set("Content-Type", "text/html")
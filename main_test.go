@@ -0,0 +1,282 @@
+package main
+
+import (
+	"maps"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// Start of the synthetic test tracks
+var testStart = time.Date(2024, 6, 1, 8, 0, 0, 0, time.UTC)
+
+// Pointer to a copy of v
+func ptr[T any](v T) *T {
+	return &v
+}
+
+// Waypoint of the default rider at lat, lng, recorded at after past
+// testStart
+func testWaypoint(lat, lng float64, after time.Duration) Waypoint {
+	return Waypoint{Location: &GPSCoords{Latitude: lat, Longitude: lng}, Timestamp: testStart.Add(after)}
+}
+
+// Waypoint like testWaypoint with an elevation
+func testElevated(lat, lng, ele float64, after time.Duration) Waypoint {
+	wp := testWaypoint(lat, lng, after)
+	wp.Location.Elevation = &ele
+	return wp
+}
+
+// Waypoint like testWaypoint of the given rider
+func testRiderWaypoint(rider string, lat, lng float64, after time.Duration) Waypoint {
+	wp := testWaypoint(lat, lng, after)
+	wp.Rider = rider
+	return wp
+}
+
+// Whether two coordinates agree to about 10 m
+func nearCoords(got GPSCoords, lat, lng float64) bool {
+	return math.Abs(got.Latitude-lat) < 1e-4 && math.Abs(got.Longitude-lng) < 1e-4
+}
+
+func TestExtractGPSCoordsHEIC(t *testing.T) {
+	app := &App{}
+	location, err := app.extractGPSCoords("testdata/gps.heic")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !nearCoords(location.Coords, 48.2082, 16.3738) {
+		t.Errorf("coords = %+v, want 48.2082, 16.3738", location.Coords)
+	}
+	if want := time.Date(2024, 6, 2, 9, 15, 0, 0, time.Local); !location.Taken.Equal(want) {
+		t.Errorf("taken = %v, want %v", location.Taken, want)
+	}
+}
+
+func TestDistanceKmFastError(t *testing.T) {
+	tests := []struct {
+		name                   string
+		lat1, lng1, lat2, lng2 float64
+		maxErrorKm             float64
+	}{
+		// Neighboring waypoints, where the approximation is used
+		{"short", 48.1372, 11.5756, 48.1400, 11.5800, 0.001},
+		{"short across the antimeridian", -16.5, 179.999, -16.501, -179.999, 0.001},
+		{"short near the pole", 78.2232, 15.6267, 78.2260, 15.6400, 0.001},
+		// Munich to Vienna, far beyond its intended use but still close
+		{"long", 48.1372, 11.5756, 48.2082, 16.3738, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			exact := distanceKm(tt.lat1, tt.lng1, tt.lat2, tt.lng2)
+			fast := distanceKmFast(tt.lat1, tt.lng1, tt.lat2, tt.lng2)
+			if diff := math.Abs(fast - exact); diff > tt.maxErrorKm {
+				t.Errorf("fast = %f km, exact = %f km, off by %f km", fast, exact, diff)
+			}
+		})
+	}
+}
+
+func BenchmarkDistanceKm(b *testing.B) {
+	for b.Loop() {
+		distanceKm(48.1372, 11.5756, 48.1400, 11.5800)
+	}
+}
+
+func BenchmarkDistanceKmFast(b *testing.B) {
+	for b.Loop() {
+		distanceKmFast(48.1372, 11.5756, 48.1400, 11.5800)
+	}
+}
+
+func TestParseImageExtensions(t *testing.T) {
+	exts := parseImageExtensions(" JPG, .png ,,")
+	if got := slices.Sorted(maps.Keys(exts)); !slices.Equal(got, []string{".jpg", ".png"}) {
+		t.Errorf("extensions = %v, want [.jpg .png]", got)
+	}
+
+	defaults := parseImageExtensions(" , ")
+	if len(defaults) != len(defaultImageExtensions) {
+		t.Errorf("empty list gave %d extensions, want the %d defaults", len(defaults), len(defaultImageExtensions))
+	}
+}
+
+func TestScanImagesCustomExtensions(t *testing.T) {
+	app := &App{config: &Config{ImagesDir: "testdata", ImageExts: parseImageExtensions("png,WEBP")}}
+	app.scanImages()
+
+	// gps.jpg and gps.heic aren't scanned, no-exif.png has no location
+	if got := slices.Sorted(maps.Keys(app.imageLocations)); !slices.Equal(got, []string{"gps.png", "gps.webp"}) {
+		t.Fatalf("scanned %v, want [gps.png gps.webp]", got)
+	}
+	if webp := app.imageLocations["gps.webp"].Coords; !nearCoords(webp, 47.8095, -13.055) {
+		t.Errorf("gps.webp coords = %+v, want 47.8095, -13.055", webp)
+	}
+}
+
+// App with an empty track persisting waypoints to dataDir
+func testApp(dataDir string) *App {
+	return &App{
+		config:         &Config{DataDir: dataDir},
+		latestByRider:  make(map[string]time.Time),
+		spikes:         make(map[string]Waypoint),
+		imageLocations: make(map[string]ImageLocation),
+		codes:          make(map[string]time.Time),
+		seenIDs:        newIDSet(maxSeenIDs),
+		trackFiles:     make(map[string]*trackImport),
+		live:           newLiveHub(),
+	}
+}
+
+func TestRecordWaypointWriteFailure(t *testing.T) {
+	dataDir := filepath.Join(t.TempDir(), "data")
+	app := testApp(dataDir)
+
+	// The data directory doesn't exist, so the waypoint can't be written
+	if !app.recordWaypoint(testWaypoint(47, 11, 0)) {
+		t.Fatal("waypoint not recorded")
+	}
+	if len(app.waypoints) != 1 || !app.persistFailing {
+		t.Fatalf("kept %d waypoints, failing %v, want 1 kept and failing", len(app.waypoints), app.persistFailing)
+	}
+
+	if err := os.Mkdir(dataDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if !app.recordWaypoint(testWaypoint(47.01, 11, time.Minute)) {
+		t.Fatal("waypoint not recorded")
+	}
+	if len(app.waypoints) != 2 || app.persistFailing {
+		t.Errorf("kept %d waypoints, failing %v, want 2 kept and recovered", len(app.waypoints), app.persistFailing)
+	}
+	if files, _ := os.ReadDir(dataDir); len(files) != 1 {
+		t.Errorf("wrote %d files, want only the second waypoint", len(files))
+	}
+}
+
+func TestRecordWaypointSpike(t *testing.T) {
+	app := testApp(t.TempDir())
+	app.config.MaxSpeedKmh = 200
+	east := 50 / (111.2 * math.Cos(47*math.Pi/180))
+
+	// Riding north at 30 km/h with a position 50 km east in between
+	steps := []struct {
+		wp   Waypoint
+		want bool
+	}{
+		{testWaypoint(47, 11, 0), true},
+		{testWaypoint(47.0045, 11, time.Minute), true},
+		{testWaypoint(47.009, 11+east, 2*time.Minute), false},
+		{testWaypoint(47.0135, 11, 3*time.Minute), true},
+		// After a transfer too fast to ride, the first position is held back
+		// until the next one continues from it
+		{testWaypoint(47.0135, 11+east, 10*time.Minute), false},
+		{testWaypoint(47.018, 11+east, 11*time.Minute), true},
+	}
+	for i, step := range steps {
+		if got := app.recordWaypoint(step.wp); got != step.want {
+			t.Errorf("waypoint %d recorded = %v, want %v", i, got, step.want)
+		}
+	}
+
+	if len(app.waypoints) != 4 || !nearCoords(*app.waypoints[3].Location, 47.018, 11+east) {
+		t.Errorf("recorded %d waypoints, want 4 ending after the transfer", len(app.waypoints))
+	}
+}
+
+func TestRepeatedPosition(t *testing.T) {
+	dataDir := t.TempDir()
+	app := testApp(dataDir)
+	app.config.PruneDistanceKm = 0.05
+	app.config.MinInterval = 10 * time.Minute
+	if !app.recordWaypoint(testRiderWaypoint("alice", 47, 11, 0)) {
+		t.Fatal("waypoint not recorded")
+	}
+
+	tests := []struct {
+		name string
+		wp   Waypoint
+		want bool
+	}{
+		{"same position", testRiderWaypoint("alice", 47, 11, time.Minute), true},
+		{"within the prune distance", testRiderWaypoint("alice", 47.0002, 11, time.Minute), true},
+		{"moved", testRiderWaypoint("alice", 47.01, 11, time.Minute), false},
+		{"after the interval", testRiderWaypoint("alice", 47, 11, 10*time.Minute), false},
+		{"other rider", testRiderWaypoint("bob", 47, 11, time.Minute), false},
+	}
+	for _, tt := range tests {
+		if got := app.repeatedPosition(tt.wp); got != tt.want {
+			t.Errorf("%s: repeated = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	// After a restart the position is compared to the stored waypoints
+	restarted := testApp(dataDir)
+	restarted.config = app.config
+	restarted.loadWaypoints()
+	if !restarted.repeatedPosition(testRiderWaypoint("alice", 47, 11, time.Minute)) {
+		t.Error("first position after a restart not recognized as repeated")
+	}
+}
+
+func TestRiderTracks(t *testing.T) {
+	waypoints := []Waypoint{
+		testRiderWaypoint("anna", 47.0, 11.0, 0),
+		testRiderWaypoint("ben", 48.0, 12.0, 0),
+		testRiderWaypoint("anna", 47.1, 11.1, time.Minute),
+		testRiderWaypoint("ben", 48.1, 12.1, time.Minute),
+		testRiderWaypoint("ben", 48.2, 12.2, 2*time.Minute),
+	}
+
+	tracks := riderTracks(waypoints)
+	if got := slices.Sorted(maps.Keys(tracks)); !slices.Equal(got, []string{"anna", "ben"}) {
+		t.Fatalf("riders = %q, want anna and ben", got)
+	}
+	if len(tracks["anna"]) != 2 || tracks["anna"][1][0] != 47.1 {
+		t.Errorf("anna's track = %v", tracks["anna"])
+	}
+	if len(tracks["ben"]) != 3 || tracks["ben"][2][0] != 48.2 {
+		t.Errorf("ben's track = %v", tracks["ben"])
+	}
+}
+
+func TestLoadWaypointsOrdersOffsetsAsUTC(t *testing.T) {
+	dir := t.TempDir()
+	app := testApp(filepath.Join(dir, "data"))
+	app.config.GpxDir = filepath.Join(dir, "gpx")
+	for _, sub := range []string{app.config.DataDir, app.config.GpxDir} {
+		if err := os.Mkdir(sub, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 08:05 UTC, between the two points of the GPX import
+	recorded := `{"location":{"lat":47.05,"lng":11},"updatedAt":"2024-06-01T10:05:00+02:00"}`
+	if err := os.WriteFile(filepath.Join(app.config.DataDir, "tracking.json"), []byte(recorded), 0644); err != nil {
+		t.Fatal(err)
+	}
+	gpx := `<gpx><trk><trkseg>
+  <trkpt lat="47.0" lon="11"><time>2024-06-01T08:00:00Z</time></trkpt>
+  <trkpt lat="47.1" lon="11"><time>2024-06-01T08:10:00Z</time></trkpt>
+</trkseg></trk></gpx>`
+	if err := os.WriteFile(filepath.Join(app.config.GpxDir, "ride.gpx"), []byte(gpx), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	app.loadWaypoints()
+	if len(app.waypoints) != 3 {
+		t.Fatalf("loaded %d waypoints, want 3", len(app.waypoints))
+	}
+	for i, lat := range []float64{47.0, 47.05, 47.1} {
+		wp := app.waypoints[i]
+		if wp.Location.Latitude != lat || wp.Timestamp.Location() != time.UTC {
+			t.Errorf("waypoint %d at %v, %v, want %v in UTC", i, wp.Location.Latitude, wp.Timestamp, lat)
+		}
+	}
+}
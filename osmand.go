@@ -0,0 +1,85 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Parse the OsmAnd device allowlist, comma-separated device ids optionally
+// followed by the rider as "id=rider". Devices without a rider belong to the
+// default rider.
+func parseOsmAndDevices(list string) map[string]string {
+	devices := make(map[string]string)
+	for _, entry := range strings.Split(list, ",") {
+		id, rider, _ := strings.Cut(entry, "=")
+		if id = strings.TrimSpace(id); id != "" {
+			devices[id] = strings.TrimSpace(rider)
+		}
+	}
+
+	return devices
+}
+
+// Parse an OsmAnd timestamp, Unix seconds or milliseconds or RFC3339
+func parseOsmAndTimestamp(value string) (time.Time, error) {
+	if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+		if n <= 0 {
+			return time.Time{}, errors.New("timestamp must be positive")
+		}
+		// Seconds would be past the year 30000 here
+		if n >= 1e12 {
+			return time.UnixMilli(n).UTC(), nil
+		}
+		return time.Unix(n, 0).UTC(), nil
+	}
+
+	timestamp, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return timestamp.UTC(), nil
+}
+
+// Handle positions sent with the OsmAnd protocol of Traccar Client and
+// similar apps, as query or form parameters. Only devices on the allowlist
+// are accepted, the response body is empty as the apps expect.
+//
+// Parameters:
+//
+//	id         device id, also accepted as deviceid
+//	lat, lon   position in degrees
+//	timestamp  Unix seconds or milliseconds or RFC3339
+//	altitude   optional elevation in meters
+func (app *App) handleOsmAnd(w http.ResponseWriter, r *http.Request) {
+	id := r.FormValue("id")
+	if id == "" {
+		id = r.FormValue("deviceid")
+	}
+	rider, allowed := app.config.OsmAndDevices[id]
+	if id == "" || !allowed {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	lat, errLat := strconv.ParseFloat(r.FormValue("lat"), 64)
+	lon, errLon := strconv.ParseFloat(r.FormValue("lon"), 64)
+	coords := GPSCoords{Latitude: lat, Longitude: lon}
+	if errLat != nil || errLon != nil || !validCoords(coords) {
+		http.Error(w, "Invalid coordinates", http.StatusBadRequest)
+		return
+	}
+	coords.Elevation = parseElevation(r.FormValue("altitude"))
+
+	timestamp, err := parseOsmAndTimestamp(r.FormValue("timestamp"))
+	if err != nil {
+		http.Error(w, "Invalid timestamp", http.StatusBadRequest)
+		return
+	}
+
+	app.recordWaypoint(Waypoint{Location: &coords, Timestamp: timestamp, Rider: rider})
+	w.WriteHeader(http.StatusOK)
+}
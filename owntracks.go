@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// Message posted by the OwnTracks app in HTTP mode, only the fields of
+// location messages are decoded
+type ownTracksMessage struct {
+	Type      string   `json:"_type"`
+	Lat       *float64 `json:"lat"`
+	Lon       *float64 `json:"lon"`
+	Alt       *float64 `json:"alt"`
+	Timestamp int64    `json:"tst"`
+}
+
+// Handle location updates of the OwnTracks app. The access code is sent as
+// the password of the app's HTTP authentication or like for other requests.
+// Messages other than locations are acknowledged and ignored.
+//
+// Query parameters:
+//
+//	rider  rider the phone belongs to, the default rider if empty
+func (app *App) handleOwnTracks(w http.ResponseWriter, r *http.Request) {
+	_, password, hasAuth := r.BasicAuth()
+	if !app.hasAccess(r) && !(hasAuth && app.validCode(password)) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	var msg ownTracksMessage
+	if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
+		http.Error(w, "Invalid message", http.StatusBadRequest)
+		return
+	}
+
+	if msg.Type == "location" {
+		if msg.Lat == nil || msg.Lon == nil || msg.Timestamp <= 0 {
+			http.Error(w, "Location requires lat, lon and tst", http.StatusBadRequest)
+			return
+		}
+
+		coords := GPSCoords{Latitude: *msg.Lat, Longitude: *msg.Lon, Elevation: msg.Alt}
+		if !validCoords(coords) {
+			http.Error(w, "Invalid coordinates", http.StatusBadRequest)
+			return
+		}
+
+		app.recordWaypoint(Waypoint{
+			Location:  &coords,
+			Timestamp: time.Unix(msg.Timestamp, 0).UTC(),
+			Rider:     r.URL.Query().Get("rider"),
+		})
+	}
+
+	// The app expects a list of messages to deliver back to it
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte("[]"))
+}
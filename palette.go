@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Comma-separated list of hex colors used for tracks, e.g. "#e41a1c,#377eb8"
+const paletteEnv = "TOURMAP_PALETTE"
+
+var defaultPalette = []string{"#e41a1c", "#377eb8", "#4daf4a", "#984ea3", "#ff7f00", "#a65628", "#f781bf"}
+
+var hexColor = regexp.MustCompile(`^#([0-9a-fA-F]{3}|[0-9a-fA-F]{6})$`)
+
+// Parse a comma-separated color list, falling back to the default palette
+func parsePalette(list string) ([]string, error) {
+	palette := make([]string, 0)
+	for _, color := range strings.Split(list, ",") {
+		color = strings.TrimSpace(color)
+		if color == "" {
+			continue
+		}
+
+		if !strings.HasPrefix(color, "#") {
+			color = "#" + color
+		}
+
+		if !hexColor.MatchString(color) {
+			return nil, fmt.Errorf("invalid color %q", color)
+		}
+
+		palette = append(palette, strings.ToLower(color))
+	}
+
+	if len(palette) == 0 {
+		return defaultPalette, nil
+	}
+
+	return palette, nil
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParsePalette(t *testing.T) {
+	palette, err := parsePalette(" #E41A1C, 377eb8 ,,#abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"#e41a1c", "#377eb8", "#abc"}; !slices.Equal(palette, want) {
+		t.Errorf("palette = %q, want %q", palette, want)
+	}
+
+	if palette, _ := parsePalette(""); !slices.Equal(palette, defaultPalette) {
+		t.Errorf("empty list gave %q, want the default palette", palette)
+	}
+
+	if _, err := parsePalette("#e41a1c,red"); err == nil {
+		t.Error("color name accepted")
+	}
+}
+
+// Palette embedded in the index page rendered for cfg
+func renderedPalette(t *testing.T, cfg *Config) []string {
+	t.Helper()
+
+	app := testApp(t.TempDir())
+	app.config = cfg
+	app.index = template.Must(template.New("index").Parse(tmpl))
+	rec := httptest.NewRecorder()
+	app.handleIndex(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d, want 200", rec.Code)
+	}
+
+	page := rec.Body.String()
+	_, rest, _ := strings.Cut(page, `<script id="palette" type="application/json">`)
+	data, _, _ := strings.Cut(rest, "</script>")
+	var palette []string
+	if err := json.Unmarshal([]byte(data), &palette); err != nil {
+		t.Fatalf("palette %q: %v", data, err)
+	}
+
+	return palette
+}
+
+func TestIndexPalette(t *testing.T) {
+	configured, err := loadConfig([]string{"-palette", "#112233, 445566"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := renderedPalette(t, configured); !slices.Equal(got, []string{"#112233", "#445566"}) {
+		t.Errorf("page palette = %q, want the configured colors", got)
+	}
+
+	defaults, err := loadConfig(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := renderedPalette(t, defaults); !slices.Equal(got, defaultPalette) {
+		t.Errorf("page palette = %q, want the default palette", got)
+	}
+}
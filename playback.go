@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// Default time between playback positions
+const defaultPlaybackInterval = time.Minute
+
+// Upper bound of positions per rider, protects against tiny intervals
+const maxPlaybackBuckets = 100000
+
+// Positions closer than this to the previous one count as standing still
+const playbackMinMoveKm = 0.01
+
+// Response of /api/playback
+type PlaybackResponse struct {
+	Interval string `json:"interval"`
+	// Positions of the default rider, or of the only rider if there is just one
+	Waypoints []TimedPoint            `json:"waypoints"`
+	Tracks    map[string][]TimedPoint `json:"tracks"`
+}
+
+// Handle replay requests with one position per time interval
+//
+// Query parameters:
+//
+//	interval  Go duration between positions, defaults to 1m
+func (app *App) handlePlayback(w http.ResponseWriter, r *http.Request) {
+	interval := defaultPlaybackInterval
+	if value := r.URL.Query().Get("interval"); value != "" {
+		var err error
+		interval, err = time.ParseDuration(value)
+		if err != nil || interval <= 0 {
+			http.Error(w, "Invalid interval", http.StatusBadRequest)
+			return
+		}
+	}
+
+	tracks := make(map[string][]TimedPoint)
+	for rider, track := range groupByRider(app.visibleWaypoints(r)) {
+		if track[len(track)-1].Timestamp.Sub(track[0].Timestamp)/interval > maxPlaybackBuckets {
+			http.Error(w, "Interval too small", http.StatusBadRequest)
+			return
+		}
+		tracks[rider] = playbackPositions(track, interval)
+	}
+
+	response := PlaybackResponse{
+		Interval:  interval.String(),
+		Waypoints: defaultTrack(tracks),
+		Tracks:    tracks,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// Pick the waypoint closest in time to every interval boundary, starting at
+// the first waypoint. Boundaries without movement since the last emitted
+// position are skipped.
+func playbackPositions(waypoints []Waypoint, interval time.Duration) []TimedPoint {
+	positions := make([]TimedPoint, 0)
+	if len(waypoints) == 0 {
+		return positions
+	}
+
+	var last *GPSCoords
+	end := waypoints[len(waypoints)-1].Timestamp
+	i := 0
+	for boundary := waypoints[0].Timestamp; !boundary.After(end); boundary = boundary.Add(interval) {
+		for i+1 < len(waypoints) && absDuration(waypoints[i+1].Timestamp.Sub(boundary)) <= absDuration(waypoints[i].Timestamp.Sub(boundary)) {
+			i++
+		}
+
+		wp := waypoints[i]
+		if last != nil && distanceKm(last.Latitude, last.Longitude, wp.Location.Latitude, wp.Location.Longitude) < playbackMinMoveKm {
+			continue
+		}
+
+		last = wp.Location
+		positions = append(positions, TimedPoint{
+			Lat:  wp.Location.Latitude,
+			Lng:  wp.Location.Longitude,
+			Time: wp.Timestamp,
+		})
+	}
+
+	return positions
+}
+
+func absDuration(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"time"
+)
+
+// How the latest part of the track is hidden from anonymous viewers
+const restrictionModeEnv = "TOURMAP_RESTRICTION_MODE"
+
+// Default distance around the latest position hidden from anonymous viewers
+const defaultRestrictionKm = 10.0
+
+type RestrictionMode string
+
+const (
+	// Hide everything within a straight-line radius of the latest position
+	RestrictRadius RestrictionMode = "radius"
+	// Hide the most recently traveled distance along the track
+	RestrictPath RestrictionMode = "path"
+)
+
+// Parse the restriction mode, defaults to radius
+func parseRestrictionMode(value string) (RestrictionMode, error) {
+	switch mode := RestrictionMode(value); mode {
+	case "":
+		return RestrictRadius, nil
+	case RestrictRadius, RestrictPath:
+		return mode, nil
+	default:
+		return RestrictRadius, fmt.Errorf("unknown mode %q, expected %s or %s", value, RestrictRadius, RestrictPath)
+	}
+}
+
+// Hide the trailing part of the track within km of the latest position
+func restrictWaypoints(waypoints []Waypoint, mode RestrictionMode, km float64) []Waypoint {
+	if mode == RestrictPath {
+		return restrictToPath(waypoints, km)
+	}
+
+	return restrictToRadius(waypoints, km)
+}
+
+// Hide the waypoints within a straight-line distance of km around the
+// latest position. The result ends with the last waypoint beyond it.
+func restrictToRadius(waypoints []Waypoint, km float64) []Waypoint {
+	if len(waypoints) == 0 {
+		return waypoints
+	}
+
+	last := waypoints[len(waypoints)-1].Location
+	i := len(waypoints) - 1
+	for ; i >= 0; i-- {
+		loc := waypoints[i].Location
+		if distanceKm(last.Latitude, last.Longitude, loc.Latitude, loc.Longitude) > km {
+			break
+		}
+	}
+
+	return waypoints[:i+1]
+}
+
+// Hide the last km traveled along the track
+func restrictToPath(waypoints []Waypoint, km float64) []Waypoint {
+	traveled := 0.0
+	i := len(waypoints) - 1
+	for ; i >= 0; i-- {
+		if i < len(waypoints)-1 {
+			loc, next := waypoints[i].Location, waypoints[i+1].Location
+			traveled += distanceKm(next.Latitude, next.Longitude, loc.Latitude, loc.Longitude)
+		}
+		if traveled > km {
+			break
+		}
+	}
+
+	return waypoints[:i+1]
+}
+
+// Restrict the track of every rider separately, so each rider's latest
+// position is hidden
+func restrictRiders(waypoints []Waypoint, mode RestrictionMode, km float64) []Waypoint {
+	return mapRiders(waypoints, func(track []Waypoint) []Waypoint {
+		return restrictWaypoints(track, mode, km)
+	})
+}
+
+// Drop the images in the area hidden from anonymous viewers, those within km
+// of a rider's latest position. Images taken after the last visible waypoint
+// of every rider are dropped as well, as they show where the hidden part of
+// the track went.
+func restrictImages(images map[string]ImageLocation, waypoints []Waypoint, mode RestrictionMode, km float64) map[string]ImageLocation {
+	tracks := groupByRider(waypoints)
+	if len(tracks) == 0 {
+		return images
+	}
+
+	var cutoff time.Time
+	latest := make([]*GPSCoords, 0, len(tracks))
+	for _, track := range tracks {
+		latest = append(latest, track[len(track)-1].Location)
+		if visible := restrictWaypoints(track, mode, km); len(visible) > 0 && visible[len(visible)-1].Timestamp.After(cutoff) {
+			cutoff = visible[len(visible)-1].Timestamp
+		}
+	}
+
+	restricted := make(map[string]ImageLocation, len(images))
+	for filename, image := range images {
+		if !image.Taken.IsZero() && image.Taken.After(cutoff) {
+			continue
+		}
+
+		hidden := slices.ContainsFunc(latest, func(loc *GPSCoords) bool {
+			return distanceKm(loc.Latitude, loc.Longitude, image.Coords.Latitude, image.Coords.Longitude) <= km
+		})
+		if !hidden {
+			restricted[filename] = image
+		}
+	}
+
+	return restricted
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// Degrees of latitude spanning km along a meridian
+func latitudeDegrees(km float64) float64 {
+	return km / (6371.0 * math.Pi / 180)
+}
+
+// Track heading north to 47.0, 11.0 with one waypoint at each of the given
+// distances before the end, first one first
+func trackTowards(kms ...float64) []Waypoint {
+	waypoints := make([]Waypoint, len(kms))
+	for i, km := range kms {
+		waypoints[i] = testWaypoint(47.0-latitudeDegrees(km), 11.0, time.Duration(i)*time.Minute)
+	}
+
+	return waypoints
+}
+
+func TestRestrictToRadiusBoundary(t *testing.T) {
+	waypoints := trackTowards(20, 10.1, 9.9, 5, 0)
+
+	restricted := restrictToRadius(waypoints, 10)
+	if len(restricted) != 2 {
+		t.Fatalf("kept %d waypoints, want the 2 beyond 10 km", len(restricted))
+	}
+	last := restricted[len(restricted)-1].Location
+	if d := distanceKm(last.Latitude, last.Longitude, 47.0, 11.0); math.Abs(d-10.1) > 1e-6 {
+		t.Errorf("last visible waypoint is %f km away, want 10.1", d)
+	}
+}
+
+func TestRestrictOutAndBack(t *testing.T) {
+	// 6 km out and 5 km back in 500 m steps, ending 1 km from the start
+	var waypoints []Waypoint
+	for i := 0; i <= 22; i++ {
+		km := min(float64(i), float64(24-i)) * 0.5
+		waypoints = append(waypoints, testWaypoint(47+latitudeDegrees(km), 11, time.Duration(i)*time.Minute))
+	}
+
+	// The whole track lies within 9.8 km of the latest position
+	if radius := restrictWaypoints(waypoints, RestrictRadius, 9.8); len(radius) != 0 {
+		t.Errorf("radius mode kept %d waypoints, want none", len(radius))
+	}
+
+	// Only the last 9.8 km ridden are hidden, the first 1 km stays visible
+	if path := restrictWaypoints(waypoints, RestrictPath, 9.8); len(path) != 3 {
+		t.Errorf("path mode kept %d waypoints, want 3", len(path))
+	}
+}
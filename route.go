@@ -0,0 +1,272 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const defaultRouteDir = "./route"
+
+// Load the planned routes of all GPX and GeoJSON files in dir. Routes are
+// kept apart from the recorded track and never merged with it. A missing
+// directory is not an error as planned routes are optional.
+func loadRouteFiles(dir string) [][]Waypoint {
+	routes := make([][]Waypoint, 0)
+
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+
+		var parsed [][]Waypoint
+		switch strings.ToLower(filepath.Ext(path)) {
+		case ".gpx":
+			parsed, err = parseGpxRouteFile(path)
+		case ".geojson", ".json":
+			var route []Waypoint
+			route, err = parseGeoJsonFile(path)
+			parsed = [][]Waypoint{route}
+		default:
+			return nil
+		}
+		if err != nil {
+			slog.Error("Error parsing route file", "path", path, "error", err)
+			return nil
+		}
+
+		for _, route := range parsed {
+			if len(route) > 0 {
+				routes = append(routes, route)
+			}
+		}
+		return nil
+	})
+
+	if errors.Is(err, fs.ErrNotExist) {
+		return routes
+	} else if err != nil {
+		slog.Error("Error walking route directory", "path", dir, "error", err)
+	}
+
+	if len(routes) > 0 {
+		slog.Info("Loaded route files", "path", dir, "route_count", len(routes))
+	}
+
+	return routes
+}
+
+// Parse the tracks and routes of a GPX file as planned routes, one per track
+// or route. Unlike recorded tracks, points don't need a time.
+func parseGpxRouteFile(path string) ([][]Waypoint, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var doc gpxFile
+	if err := xml.NewDecoder(file).Decode(&doc); err != nil {
+		return nil, err
+	}
+
+	pointLists := make([][]gpxPoint, 0, len(doc.Tracks)+len(doc.Routes))
+	for _, track := range doc.Tracks {
+		points := make([]gpxPoint, 0)
+		for _, segment := range track.Segments {
+			points = append(points, segment.Points...)
+		}
+		pointLists = append(pointLists, points)
+	}
+	for _, route := range doc.Routes {
+		pointLists = append(pointLists, route.Points)
+	}
+
+	routes := make([][]Waypoint, 0, len(pointLists))
+	for _, points := range pointLists {
+		route := make([]Waypoint, 0, len(points))
+		for _, point := range points {
+			lat, errLat := strconv.ParseFloat(point.Lat, 64)
+			lon, errLon := strconv.ParseFloat(point.Lon, 64)
+			if errLat != nil || errLon != nil {
+				continue
+			}
+
+			coords := GPSCoords{Latitude: lat, Longitude: lon, Elevation: parseElevation(point.Ele)}
+			if !validCoords(coords) {
+				slog.Warn("Skipping route point with invalid coordinates", "path", path, "lat", lat, "lng", lon)
+				continue
+			}
+			route = append(route, Waypoint{Location: &coords})
+		}
+		routes = append(routes, route)
+	}
+
+	return routes, nil
+}
+
+// GeoJSON object, only the parts needed for planned routes. Depending on the
+// type it is a FeatureCollection, a Feature or a bare geometry.
+type geoJSONObject struct {
+	Type        string          `json:"type"`
+	Features    []geoJSONObject `json:"features"`
+	Geometry    *geoJSONObject  `json:"geometry"`
+	Geometries  []geoJSONObject `json:"geometries"`
+	Coordinates json.RawMessage `json:"coordinates"`
+}
+
+// Parse the LineString, MultiLineString and Point geometries of a GeoJSON
+// file, e.g. a route planned with BRouter, in file order. GeoJSON has no
+// timestamps, so the waypoints are planned positions without a time and are
+// kept apart from the recorded track.
+func parseGeoJsonFile(path string) ([]Waypoint, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var doc geoJSONObject
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+
+	positions := make([][]float64, 0)
+	if err := collectPositions(doc, &positions); err != nil {
+		return nil, err
+	}
+
+	waypoints := make([]Waypoint, 0, len(positions))
+	for _, position := range positions {
+		if len(position) < 2 {
+			return nil, fmt.Errorf("position with %d values", len(position))
+		}
+
+		coords := GPSCoords{Latitude: position[1], Longitude: position[0]}
+		if len(position) > 2 {
+			ele := position[2]
+			coords.Elevation = &ele
+		}
+		if !validCoords(coords) {
+			return nil, fmt.Errorf("invalid coordinates %v", position)
+		}
+
+		waypoints = append(waypoints, Waypoint{Location: &coords})
+	}
+
+	return waypoints, nil
+}
+
+// Append the [lng, lat, ele] positions of obj and its children, other
+// geometry types are ignored
+func collectPositions(obj geoJSONObject, positions *[][]float64) error {
+	switch obj.Type {
+	case "FeatureCollection":
+		for _, feature := range obj.Features {
+			if err := collectPositions(feature, positions); err != nil {
+				return err
+			}
+		}
+	case "Feature":
+		if obj.Geometry != nil {
+			return collectPositions(*obj.Geometry, positions)
+		}
+	case "GeometryCollection":
+		for _, geometry := range obj.Geometries {
+			if err := collectPositions(geometry, positions); err != nil {
+				return err
+			}
+		}
+	case "Point":
+		var point []float64
+		if err := json.Unmarshal(obj.Coordinates, &point); err != nil {
+			return err
+		}
+		*positions = append(*positions, point)
+	case "LineString":
+		var line [][]float64
+		if err := json.Unmarshal(obj.Coordinates, &line); err != nil {
+			return err
+		}
+		*positions = append(*positions, line...)
+	case "MultiLineString":
+		var lines [][][]float64
+		if err := json.Unmarshal(obj.Coordinates, &lines); err != nil {
+			return err
+		}
+		for _, line := range lines {
+			*positions = append(*positions, line...)
+		}
+	}
+
+	return nil
+}
+
+// Share of the planned route covered in percent, measured along the route up
+// to the point closest to pos. If pos is off the route, e.g. on a detour, the
+// progress of the nearest route segment is reported. Of several routes the
+// one closest to pos is used. ok is false if there is no route to measure.
+func routeProgress(routes [][]Waypoint, pos GPSCoords) (percent float64, ok bool) {
+	scale := math.Cos(pos.Latitude * math.Pi / 180)
+	project := func(c *GPSCoords) [2]float64 {
+		return [2]float64{c.Longitude * scale * metersPerDegree, c.Latitude * metersPerDegree}
+	}
+	p := project(&pos)
+
+	nearest := math.Inf(1)
+	for _, route := range routes {
+		total := 0.0
+		for i := 1; i < len(route); i++ {
+			a, b := route[i-1].Location, route[i].Location
+			total += distanceKm(a.Latitude, a.Longitude, b.Latitude, b.Longitude)
+		}
+		if total == 0 {
+			continue
+		}
+
+		covered := 0.0
+		for i := 1; i < len(route); i++ {
+			a, b := route[i-1].Location, route[i].Location
+			length := distanceKm(a.Latitude, a.Longitude, b.Latitude, b.Longitude)
+			t, distance := projectOnSegment(p, project(a), project(b))
+			if distance < nearest {
+				nearest = distance
+				percent = (covered + t*length) / total * 100
+				ok = true
+			}
+			covered += length
+		}
+	}
+
+	return percent, ok
+}
+
+// Planned routes as lists of [lat, lng] pairs
+func (app *App) plannedRouteCoords() [][][]float64 {
+	app.wpMutex.RLock()
+	defer app.wpMutex.RUnlock()
+
+	routes := make([][][]float64, 0, len(app.plannedRoutes))
+	for _, route := range app.plannedRoutes {
+		routes = append(routes, waypointCoords(route))
+	}
+
+	return routes
+}
+
+// Handle the planned routes as lists of [lat, lng] pairs
+func (app *App) handleRoutes(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(app.plannedRouteCoords())
+}
@@ -0,0 +1,46 @@
+package main
+
+import "time"
+
+// Gap between consecutive waypoints that starts a new segment
+const defaultSegmentGap = time.Hour
+
+// Split the track into segments wherever consecutive waypoints are more
+// than maxGap apart, e.g. overnight stops on a multi-day tour
+func splitSegments(waypoints []Waypoint, maxGap time.Duration) [][]Waypoint {
+	segments := make([][]Waypoint, 0)
+	start := 0
+	for i := 1; i < len(waypoints); i++ {
+		if waypoints[i].Timestamp.Sub(waypoints[i-1].Timestamp) > maxGap {
+			segments = append(segments, waypoints[start:i])
+			start = i
+		}
+	}
+
+	if start < len(waypoints) {
+		segments = append(segments, waypoints[start:])
+	}
+
+	return segments
+}
+
+// Segments as lists of [lat, lng] pairs
+func segmentCoords(waypoints []Waypoint, maxGap time.Duration) [][][]float64 {
+	segments := splitSegments(waypoints, maxGap)
+	coords := make([][][]float64, 0, len(segments))
+	for _, segment := range segments {
+		coords = append(coords, waypointCoords(segment))
+	}
+
+	return coords
+}
+
+// Segments as lists of [lat, lng] pairs keyed by rider
+func riderSegments(waypoints []Waypoint, maxGap time.Duration) map[string][][][]float64 {
+	tracks := make(map[string][][][]float64)
+	for rider, track := range groupByRider(waypoints) {
+		tracks[rider] = segmentCoords(track, maxGap)
+	}
+
+	return tracks
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSplitSegments(t *testing.T) {
+	minutes := func(offsets ...int) []Waypoint {
+		var waypoints []Waypoint
+		for _, m := range offsets {
+			waypoints = append(waypoints, testWaypoint(47, 11, time.Duration(m)*time.Minute))
+		}
+		return waypoints
+	}
+
+	tests := []struct {
+		name      string
+		waypoints []Waypoint
+		want      []int
+	}{
+		{"empty", nil, nil},
+		{"single", minutes(0), []int{1}},
+		{"no gap", minutes(0, 10, 20), []int{3}},
+		// A gap of exactly maxGap doesn't split
+		{"gap of max", minutes(0, 60, 70), []int{3}},
+		{"two days", minutes(0, 10, 20, 24*60, 24*60+10), []int{3, 2}},
+		{"gap after first", minutes(0, 61, 70), []int{1, 2}},
+		{"gap before last", minutes(0, 10, 71), []int{2, 1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			segments := splitSegments(tt.waypoints, time.Hour)
+			if len(segments) != len(tt.want) {
+				t.Fatalf("got %d segments, want %d", len(segments), len(tt.want))
+			}
+			for i, segment := range segments {
+				if len(segment) != tt.want[i] {
+					t.Errorf("segment %d has %d waypoints, want %d", i, len(segment), tt.want[i])
+				}
+			}
+		})
+	}
+}
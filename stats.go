@@ -0,0 +1,298 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Summary statistics for a (part of a) track
+type TrackStats struct {
+	TotalDistanceKm float64 `json:"totalDistanceKm"`
+	TotalAscentM    float64 `json:"totalAscentM"`
+	AvgSpeedKmh     float64 `json:"avgSpeedKmh"`
+	// Speed between the latest two waypoints of the latest rider
+	CurrentSpeedKmh float64 `json:"currentSpeedKmh"`
+	// Seconds between the first and last waypoint
+	ElapsedTime int64 `json:"elapsedTime"`
+	// Seconds spent faster than stoppedSpeedKmh
+	MovingTime    int64      `json:"movingTime"`
+	WaypointCount int        `json:"waypointCount"`
+	StartTime     *time.Time `json:"startTime,omitempty"`
+	EndTime       *time.Time `json:"endTime,omitempty"`
+	TimeZone      string     `json:"timeZone,omitempty"`
+	// Share of the planned route covered by the latest waypoint
+	RouteProgressPercent *float64 `json:"routeProgressPercent,omitempty"`
+	// Per segment summary, e.g. the climbing of every day of the tour
+	Segments []SegmentStats `json:"segments"`
+}
+
+// Summary of a segment of a rider's track
+type SegmentStats struct {
+	Rider string `json:"rider,omitempty"`
+	// 1-based number of the segment within the rider's track
+	Segment    int       `json:"segment"`
+	StartTime  time.Time `json:"startTime"`
+	EndTime    time.Time `json:"endTime"`
+	DistanceKm float64   `json:"distanceKm"`
+	AscentM    float64   `json:"ascentM"`
+	DescentM   float64   `json:"descentM"`
+}
+
+// Trailing window of a track, either by time or by distance
+type statsWindow struct {
+	duration   time.Duration
+	distanceKm float64
+}
+
+// Parse a window like "1h" or "10km"
+func parseStatsWindow(value string) (*statsWindow, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	if km, ok := strings.CutSuffix(value, "km"); ok {
+		distance, err := strconv.ParseFloat(km, 64)
+		if err != nil || distance <= 0 {
+			return nil, fmt.Errorf("invalid distance window %q", value)
+		}
+		return &statsWindow{distanceKm: distance}, nil
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration <= 0 {
+		return nil, fmt.Errorf("invalid time window %q", value)
+	}
+	return &statsWindow{duration: duration}, nil
+}
+
+// Select the trailing waypoints of every rider covered by the window
+func (win *statsWindow) apply(waypoints []Waypoint) []Waypoint {
+	if win == nil || len(waypoints) == 0 {
+		return waypoints
+	}
+
+	return mapRiders(waypoints, win.trailing)
+}
+
+// Select the trailing waypoints of a single track covered by the window
+func (win *statsWindow) trailing(waypoints []Waypoint) []Waypoint {
+	last := waypoints[len(waypoints)-1]
+	if win.duration > 0 {
+		start := last.Timestamp.Add(-win.duration)
+		i := len(waypoints) - 1
+		for i > 0 && !waypoints[i-1].Timestamp.Before(start) {
+			i--
+		}
+		return waypoints[i:]
+	}
+
+	covered := 0.0
+	i := len(waypoints) - 1
+	for i > 0 {
+		a, b := waypoints[i-1].Location, waypoints[i].Location
+		covered += distanceKm(a.Latitude, a.Longitude, b.Latitude, b.Longitude)
+		if covered > win.distanceKm {
+			break
+		}
+		i--
+	}
+	return waypoints[i:]
+}
+
+// Present the stats times in the given timezone
+func (stats *TrackStats) localize(loc *time.Location) {
+	if stats.StartTime == nil {
+		return
+	}
+
+	start := stats.StartTime.In(loc)
+	end := stats.EndTime.In(loc)
+	stats.StartTime = &start
+	stats.EndTime = &end
+	stats.TimeZone = loc.String()
+	for i := range stats.Segments {
+		stats.Segments[i].StartTime = stats.Segments[i].StartTime.In(loc)
+		stats.Segments[i].EndTime = stats.Segments[i].EndTime.In(loc)
+	}
+}
+
+// Sum of distances between consecutive waypoints, summed over the tracks of
+// all riders
+func totalDistance(waypoints []Waypoint) float64 {
+	total := 0.0
+	for _, track := range groupByRider(waypoints) {
+		for i := 1; i < len(track); i++ {
+			a, b := track[i-1].Location, track[i].Location
+			total += distanceKm(a.Latitude, a.Longitude, b.Latitude, b.Longitude)
+		}
+	}
+
+	return total
+}
+
+// Elevation changes smaller than this are treated as GPS noise
+const ascentThresholdM = 1.0
+
+// Sum of climbs between waypoints with elevation, summed over the tracks of
+// all riders
+func totalAscent(waypoints []Waypoint) float64 {
+	ascent := 0.0
+	for _, track := range groupByRider(waypoints) {
+		climbed, _ := elevationChange(track)
+		ascent += climbed
+	}
+
+	return ascent
+}
+
+// Sum of climbs and descents along a single track. Changes are measured
+// against the last elevation that moved by at least ascentThresholdM, so
+// noise doesn't add up while slow steady climbs still count.
+func elevationChange(waypoints []Waypoint) (ascent, descent float64) {
+	var reference *float64
+	for _, wp := range waypoints {
+		ele := wp.Location.Elevation
+		if ele == nil {
+			continue
+		}
+
+		if reference == nil {
+			reference = ele
+			continue
+		}
+
+		delta := *ele - *reference
+		if delta >= ascentThresholdM {
+			ascent += delta
+			reference = ele
+		} else if delta <= -ascentThresholdM {
+			descent -= delta
+			reference = ele
+		}
+	}
+
+	return ascent, descent
+}
+
+// Summarize the segments of every rider's track, split wherever waypoints
+// are more than maxGap apart. Riders are listed in name order.
+func segmentStats(waypoints []Waypoint, maxGap time.Duration) []SegmentStats {
+	stats := make([]SegmentStats, 0)
+	tracks := groupByRider(waypoints)
+	for _, rider := range slices.Sorted(maps.Keys(tracks)) {
+		for i, segment := range splitSegments(tracks[rider], maxGap) {
+			ascent, descent := elevationChange(segment)
+			stats = append(stats, SegmentStats{
+				Rider:      rider,
+				Segment:    i + 1,
+				StartTime:  segment[0].Timestamp,
+				EndTime:    segment[len(segment)-1].Timestamp,
+				DistanceKm: totalDistance(segment),
+				AscentM:    ascent,
+				DescentM:   descent,
+			})
+		}
+	}
+
+	return stats
+}
+
+// Compute statistics for the given waypoints, with segments split wherever
+// waypoints are more than maxGap apart
+func computeStats(waypoints []Waypoint, maxGap time.Duration) TrackStats {
+	stats := TrackStats{WaypointCount: len(waypoints), Segments: segmentStats(waypoints, maxGap)}
+	if len(waypoints) == 0 {
+		return stats
+	}
+
+	stats.TotalDistanceKm = totalDistance(waypoints)
+	stats.TotalAscentM = totalAscent(waypoints)
+
+	start := waypoints[0].Timestamp
+	end := waypoints[len(waypoints)-1].Timestamp
+	stats.StartTime = &start
+	stats.EndTime = &end
+
+	// Average over the time every rider was underway, riding side by side
+	// doesn't double the speed
+	hours := 0.0
+	for _, track := range groupByRider(waypoints) {
+		hours += track[len(track)-1].Timestamp.Sub(track[0].Timestamp).Hours()
+	}
+	if hours > 0 {
+		stats.AvgSpeedKmh = stats.TotalDistanceKm / hours
+	}
+	stats.ElapsedTime = int64(end.Sub(start).Seconds())
+	stats.MovingTime = int64(movingTime(waypoints).Seconds())
+
+	last := waypoints[len(waypoints)-1]
+	for i := len(waypoints) - 2; i >= 0; i-- {
+		if waypoints[i].Rider == last.Rider {
+			stats.CurrentSpeedKmh = speedKmh(waypoints[i], last)
+			break
+		}
+	}
+
+	return stats
+}
+
+// Waypoints closer than this speed apart count as standing still
+const stoppedSpeedKmh = 1.0
+
+// Time spent between consecutive waypoints while moving faster than
+// stoppedSpeedKmh, summed over the tracks of all riders
+func movingTime(waypoints []Waypoint) time.Duration {
+	var moving time.Duration
+	for _, track := range groupByRider(waypoints) {
+		for i := 1; i < len(track); i++ {
+			a, b := track[i-1], track[i]
+			if speedKmh(a, b) > stoppedSpeedKmh {
+				moving += b.Timestamp.Sub(a.Timestamp)
+			}
+		}
+	}
+
+	return moving
+}
+
+// Speed between two waypoints, 0 if they don't have increasing timestamps
+func speedKmh(a, b Waypoint) float64 {
+	hours := b.Timestamp.Sub(a.Timestamp).Hours()
+	if hours <= 0 {
+		return 0
+	}
+
+	return distanceKm(a.Location.Latitude, a.Location.Longitude, b.Location.Latitude, b.Location.Longitude) / hours
+}
+
+// Handle track statistics, optionally over a trailing window
+func (app *App) handleStats(w http.ResponseWriter, r *http.Request) {
+	window, err := parseStatsWindow(r.URL.Query().Get("window"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	waypoints := window.apply(app.visibleWaypoints(r))
+	stats := computeStats(waypoints, app.config.SegmentGap)
+	if len(waypoints) > 0 {
+		latest := waypoints[len(waypoints)-1].Location
+		stats.localize(app.timezones.locate(latest))
+
+		app.wpMutex.RLock()
+		percent, ok := routeProgress(app.plannedRoutes, *latest)
+		app.wpMutex.RUnlock()
+		if ok {
+			stats.RouteProgressPercent = &percent
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestSegmentStatsSumToTotal(t *testing.T) {
+	// Two days with a night in between, climbing and descending on both
+	profile := []float64{500, 520, 560, 540, 600, 580, 530, 610, 650, 600}
+	waypoints := make([]Waypoint, 0, len(profile))
+	for i, ele := range profile {
+		after := time.Duration(i) * 10 * time.Minute
+		if i >= 5 {
+			after += 12 * time.Hour
+		}
+		waypoints = append(waypoints, testElevated(47+float64(i)*0.01, 11, ele, after))
+	}
+
+	stats := computeStats(waypoints, time.Hour)
+	if len(stats.Segments) != 2 {
+		t.Fatalf("got %d segments, want 2", len(stats.Segments))
+	}
+
+	var ascent, descent, distance float64
+	for _, segment := range stats.Segments {
+		ascent += segment.AscentM
+		descent += segment.DescentM
+		distance += segment.DistanceKm
+	}
+
+	// Only the change over night, from 600 to 580, belongs to no segment
+	if math.Abs(ascent-stats.TotalAscentM) > 1 {
+		t.Errorf("segment ascent sums to %v, total is %v", ascent, stats.TotalAscentM)
+	}
+	_, totalDescent := elevationChange(waypoints)
+	if math.Abs(descent+20-totalDescent) > 1 {
+		t.Errorf("segment descent sums to %v plus 20 overnight, total is %v", descent, totalDescent)
+	}
+	if math.Abs(distance+totalDistance(waypoints[4:6])-stats.TotalDistanceKm) > 0.001 {
+		t.Errorf("segment distance sums to %v, total is %v", distance, stats.TotalDistanceKm)
+	}
+
+	if got := stats.Segments[0]; got.AscentM != 120 || got.DescentM != 20 {
+		t.Errorf("first segment ascent %v descent %v, want 120 and 20", got.AscentM, got.DescentM)
+	}
+	if got := stats.Segments[1]; got.AscentM != 120 || got.DescentM != 100 {
+		t.Errorf("second segment ascent %v descent %v, want 120 and 100", got.AscentM, got.DescentM)
+	}
+}
+
+func TestStatsWindow(t *testing.T) {
+	// Waypoints 10 minutes and about 1.1 km apart
+	waypoints := make([]Waypoint, 10)
+	for i := range waypoints {
+		waypoints[i] = testWaypoint(47+float64(i)*0.01, 11, time.Duration(i)*10*time.Minute)
+	}
+
+	tests := []struct {
+		window string
+		want   int
+	}{
+		// From 30 minutes before the latest waypoint, inclusive
+		{"30m", 4},
+		// The waypoints up to 2.2 km back, the next one is 3.3 km back
+		{"2.5km", 3},
+		{"", 10},
+	}
+
+	for _, tt := range tests {
+		window, err := parseStatsWindow(tt.window)
+		if err != nil {
+			t.Fatalf("window %q: %v", tt.window, err)
+		}
+		got := window.apply(waypoints)
+		if len(got) != tt.want {
+			t.Errorf("window %q kept %d waypoints, want %d", tt.window, len(got), tt.want)
+		}
+		if len(got) > 0 && got[len(got)-1] != waypoints[len(waypoints)-1] {
+			t.Errorf("window %q doesn't end with the latest waypoint", tt.window)
+		}
+	}
+
+	for _, invalid := range []string{"0km", "-5km", "fastkm", "0s", "-1h", "soon"} {
+		if _, err := parseStatsWindow(invalid); err == nil {
+			t.Errorf("window %q parsed without error", invalid)
+		}
+	}
+}
+
+func TestTotalDistance(t *testing.T) {
+	// 0.01° of latitude is 1.112 km
+	waypoints := []Waypoint{
+		testRiderWaypoint("anna", 47.00, 11, 0),
+		testRiderWaypoint("ben", 48.00, 11, 0),
+		testRiderWaypoint("anna", 47.01, 11, time.Minute),
+		testRiderWaypoint("ben", 48.02, 11, time.Minute),
+		testRiderWaypoint("anna", 47.03, 11, 2*time.Minute),
+	}
+
+	// Riders far apart don't add the distance between them
+	if got := totalDistance(waypoints); math.Abs(got-5*1.1119) > 0.001 {
+		t.Errorf("total distance = %f km, want %f", got, 5*1.1119)
+	}
+	if got := totalDistance(waypoints[:1]); got != 0 {
+		t.Errorf("distance of a single waypoint = %f, want 0", got)
+	}
+}
+
+func TestTotalAscentUpDownUp(t *testing.T) {
+	// Up 100 m, down 50 m and up 80 m again, with jitter below the threshold
+	// and a waypoint without elevation in between
+	profile := []float64{500, 550, 600, 600.4, 599.8, 570, 550, 590, 630}
+	var waypoints []Waypoint
+	for i, ele := range profile {
+		waypoints = append(waypoints, testElevated(47+float64(i)*0.001, 11, ele, time.Duration(i)*time.Minute))
+		if i == 4 {
+			waypoints = append(waypoints, testWaypoint(47.0045, 11, time.Duration(i)*time.Minute+30*time.Second))
+		}
+	}
+
+	if got := totalAscent(waypoints); got != 180 {
+		t.Errorf("total ascent = %v, want 180", got)
+	}
+	if _, descent := elevationChange(waypoints); descent != 50 {
+		t.Errorf("descent = %v, want 50", descent)
+	}
+}
+
+func TestTotalAscentSlowClimb(t *testing.T) {
+	// 0.5 m per waypoint, each step below the threshold
+	var waypoints []Waypoint
+	for i := range 21 {
+		waypoints = append(waypoints, testElevated(47+float64(i)*0.001, 11, 500+float64(i)*0.5, time.Duration(i)*time.Minute))
+	}
+
+	if got := totalAscent(waypoints); got != 10 {
+		t.Errorf("total ascent = %v, want 10", got)
+	}
+}
+
+func TestSpeed(t *testing.T) {
+	a := testWaypoint(47.00, 11, 0)
+	b := testWaypoint(47.01, 11, 2*time.Minute)
+	if got := speedKmh(a, b); math.Abs(got-1.1119*30) > 0.01 {
+		t.Errorf("speed = %f km/h, want %f", got, 1.1119*30)
+	}
+	if got := speedKmh(b, a); got != 0 {
+		t.Errorf("speed backwards in time = %f, want 0", got)
+	}
+
+	// The current speed is the one of the rider who reported last, anna
+	// rides at 1.1 km per minute and ben at 1.1 km per 2 minutes
+	stats := computeStats([]Waypoint{
+		testRiderWaypoint("anna", 47.00, 11, 0),
+		testRiderWaypoint("ben", 48.00, 11, 0),
+		testRiderWaypoint("anna", 47.01, 11, time.Minute),
+		testRiderWaypoint("ben", 48.01, 11, 2*time.Minute),
+	}, time.Hour)
+	if math.Abs(stats.CurrentSpeedKmh-1.1119*30) > 0.01 {
+		t.Errorf("current speed = %f km/h, want ben's %f", stats.CurrentSpeedKmh, 1.1119*30)
+	}
+	// Together they covered 2.2 km in 3 minutes underway
+	if math.Abs(stats.AvgSpeedKmh-2*1.1119*20) > 0.01 {
+		t.Errorf("average speed = %f km/h, want %f", stats.AvgSpeedKmh, 2*1.1119*20)
+	}
+}
+
+func TestMovingTimeExcludesStop(t *testing.T) {
+	// Riding for 10 minutes, standing at a cafe for 10 minutes with GPS
+	// drift of a few meters, then riding for 5 minutes
+	var waypoints []Waypoint
+	for i := 0; i <= 10; i++ {
+		waypoints = append(waypoints, testWaypoint(47+float64(i)*0.005, 11, time.Duration(i)*time.Minute))
+	}
+	for i := 1; i <= 10; i++ {
+		drift := float64(i%2) * 0.00002
+		waypoints = append(waypoints, testWaypoint(47.05+drift, 11, time.Duration(10+i)*time.Minute))
+	}
+	for i := 1; i <= 5; i++ {
+		waypoints = append(waypoints, testWaypoint(47.05+float64(i)*0.005, 11, time.Duration(20+i)*time.Minute))
+	}
+
+	stats := computeStats(waypoints, time.Hour)
+	if stats.ElapsedTime != 25*60 {
+		t.Errorf("elapsed time = %ds, want 25 minutes", stats.ElapsedTime)
+	}
+	if stats.MovingTime != 15*60 {
+		t.Errorf("moving time = %ds, want 15 minutes", stats.MovingTime)
+	}
+}
+
+func TestMovingTimePerRider(t *testing.T) {
+	// Two riders riding side by side for 10 minutes ride 20 minutes in total
+	var waypoints []Waypoint
+	for i := 0; i <= 10; i++ {
+		after := time.Duration(i) * time.Minute
+		waypoints = append(waypoints,
+			testRiderWaypoint("anna", 47+float64(i)*0.005, 11, after),
+			testRiderWaypoint("ben", 47+float64(i)*0.005, 11.001, after))
+	}
+
+	if got := movingTime(waypoints); got != 20*time.Minute {
+		t.Errorf("moving time = %v, want 20m", got)
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	_ "image/png"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/tiff"
+	_ "golang.org/x/image/webp"
+)
+
+const defaultThumbsDir = "./thumbs"
+
+// Longest edge of generated thumbnails in pixels
+const thumbSize = 512
+
+// Handle thumbnails of images, generated on first request and cached on disk
+func (app *App) handleThumb(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if name == "" || name != filepath.Base(name) || strings.HasPrefix(name, ".") || !app.isImageFile(name) {
+		http.NotFound(w, r)
+		return
+	}
+
+	thumbPath, err := app.thumbnail(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		http.NotFound(w, r)
+		return
+	} else if errors.Is(err, image.ErrFormat) {
+		// e.g. HEIC, which can't be decoded without cgo
+		http.Error(w, "Unsupported image format", http.StatusUnsupportedMediaType)
+		return
+	} else if err != nil {
+		slog.Error("Error generating thumbnail", "name", name, "error", err)
+		http.Error(w, "Thumbnail error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "public, max-age=259200")
+	w.Header().Set("Content-Type", "image/jpeg")
+	http.ServeFile(w, r, thumbPath)
+}
+
+// Path of the thumbnail for an image, generating it if the image is new or
+// has changed since the thumbnail was created
+func (app *App) thumbnail(name string) (string, error) {
+	source := filepath.Join(app.config.ImagesDir, name)
+	info, err := os.Stat(source)
+	if err != nil {
+		return "", err
+	}
+
+	// The modification time is part of the name, so changed images get a
+	// new thumbnail
+	thumbPath := filepath.Join(app.config.ThumbsDir, fmt.Sprintf("%s_%d.jpg", name, info.ModTime().UnixNano()))
+
+	app.thumbsMutex.Lock()
+	defer app.thumbsMutex.Unlock()
+
+	if _, err := os.Stat(thumbPath); err == nil {
+		return thumbPath, nil
+	}
+
+	// Remove thumbnails of previous versions
+	if entries, err := os.ReadDir(app.config.ThumbsDir); err == nil {
+		for _, entry := range entries {
+			if isThumbnailOf(entry.Name(), name) {
+				os.Remove(filepath.Join(app.config.ThumbsDir, entry.Name()))
+			}
+		}
+	}
+
+	if err := os.MkdirAll(app.config.ThumbsDir, 0755); err != nil {
+		return "", err
+	}
+
+	if err := writeThumbnail(source, thumbPath); err != nil {
+		return "", err
+	}
+
+	return thumbPath, nil
+}
+
+// Whether the file is a thumbnail of the named image, "name_mtime.jpg".
+// Matched by hand rather than with a glob, as image names may contain glob
+// characters and names of other images may start with this one's.
+func isThumbnailOf(file, name string) bool {
+	version, ok := strings.CutPrefix(file, name+"_")
+	if !ok {
+		return false
+	}
+
+	version, ok = strings.CutSuffix(version, ".jpg")
+	if !ok {
+		return false
+	}
+
+	_, err := strconv.ParseInt(version, 10, 64)
+	return err == nil
+}
+
+// Scale the source image down to thumbSize and write it as JPEG
+func writeThumbnail(source, target string) error {
+	file, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	src, _, err := image.Decode(file)
+	if err != nil {
+		return err
+	}
+
+	bounds := src.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width > thumbSize || height > thumbSize {
+		if width >= height {
+			width, height = thumbSize, max(1, height*thumbSize/width)
+		} else {
+			width, height = max(1, width*thumbSize/height), thumbSize
+		}
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, bounds, draw.Src, nil)
+
+	// Write to a temporary file first so no partial thumbnail is served
+	return writeFileAtomic(target, func(w io.Writer) error {
+		return jpeg.Encode(w, dst, &jpeg.Options{Quality: 80})
+	})
+}
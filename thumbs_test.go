@@ -0,0 +1,88 @@
+package main
+
+import (
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestIsThumbnailOf(t *testing.T) {
+	tests := []struct {
+		file, name string
+		want       bool
+	}{
+		{"a.jpg_1718000000000000000.jpg", "a.jpg", true},
+		{"[x]*?.jpg_17.jpg", "[x]*?.jpg", true},
+		{"x.jpg_17.jpg", "[x]*?.jpg", false},
+		// Thumbnail of another image whose name starts with this one's
+		{"a.jpg_b.jpg_17.jpg", "a.jpg", false},
+		{"a.jpg_17.png", "a.jpg", false},
+		{"a.jpg_.jpg", "a.jpg", false},
+	}
+
+	for _, tt := range tests {
+		if got := isThumbnailOf(tt.file, tt.name); got != tt.want {
+			t.Errorf("isThumbnailOf(%q, %q) = %v, want %v", tt.file, tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestThumbnailReplacesStale(t *testing.T) {
+	dir := t.TempDir()
+	app := testApp(dir)
+	app.config.ImagesDir = filepath.Join(dir, "images")
+	app.config.ThumbsDir = filepath.Join(dir, "thumbs")
+	for _, sub := range []string{app.config.ImagesDir, app.config.ThumbsDir} {
+		if err := os.Mkdir(sub, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A glob character in the name, next to an image matching it as pattern
+	writeImage := func(name string, modified time.Time) {
+		path := filepath.Join(app.config.ImagesDir, name)
+		file, err := os.Create(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer file.Close()
+		if err := png.Encode(file, image.NewGray(image.Rect(0, 0, 4, 4))); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, modified, modified); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeImage("[ab].png", testStart)
+	writeImage("a.png", testStart)
+
+	other, err := app.thumbnail("a.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, err := app.thumbnail("[ab].png")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeImage("[ab].png", testStart.Add(time.Hour))
+	second, err := app.thumbnail("[ab].png")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entries, _ := os.ReadDir(app.config.ThumbsDir)
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	want := []string{filepath.Base(second), filepath.Base(other)}
+	slices.Sort(want)
+	if !slices.Equal(names, want) || first == second {
+		t.Errorf("thumbnails = %q, want only %q", names, want)
+	}
+}
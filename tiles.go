@@ -0,0 +1,155 @@
+package main
+
+import (
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/encoding/mvt"
+	"github.com/paulmach/orb/geojson"
+	"github.com/paulmach/orb/maptile"
+)
+
+// Handle Mapbox Vector Tiles of the visible tracks at /tiles/{z}/{x}/{y}.mvt,
+// with a line per segment of every rider
+func (app *App) handleTile(w http.ResponseWriter, r *http.Request) {
+	z, errZ := strconv.ParseUint(r.PathValue("z"), 10, 32)
+	x, errX := strconv.ParseUint(r.PathValue("x"), 10, 32)
+	yName, isMVT := strings.CutSuffix(r.PathValue("y"), ".mvt")
+	y, errY := strconv.ParseUint(yName, 10, 32)
+	if errZ != nil || errX != nil || errY != nil || !isMVT || z > 30 || x >= 1<<z || y >= 1<<z {
+		http.Error(w, "Invalid tile", http.StatusBadRequest)
+		return
+	}
+
+	// Only the parts of the track near the tile are projected and clipped,
+	// the buffer matches the one the clipping keeps
+	tile := maptile.New(uint32(x), uint32(y), maptile.Zoom(z))
+	bound := tile.Bound(1)
+
+	track := geojson.NewFeatureCollection()
+	for _, segment := range app.visibleTileLines(r) {
+		for _, line := range linesWithin(segment.line, bound) {
+			feature := geojson.NewFeature(line)
+			if segment.rider != "" {
+				feature.Properties["rider"] = segment.rider
+			}
+			track.Append(feature)
+		}
+	}
+
+	layers := mvt.NewLayers(map[string]*geojson.FeatureCollection{"track": track})
+	layers.ProjectToTile(tile)
+	layers.Clip(mvt.MapboxGLDefaultExtentBound)
+	layers.RemoveEmpty(0, 0)
+
+	data, err := mvt.Marshal(layers)
+	if err != nil {
+		http.Error(w, "Tile encoding error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/vnd.mapbox-vector-tile")
+	w.Write(data)
+}
+
+// Segment of a rider's track as a line in lng, lat order
+type tileLine struct {
+	rider string
+	line  orb.LineString
+}
+
+// Lines of every rider's track segments, in rider name order
+func riderTileLines(waypoints []Waypoint, maxGap time.Duration) []tileLine {
+	lines := make([]tileLine, 0)
+	tracks := groupByRider(waypoints)
+	for _, rider := range slices.Sorted(maps.Keys(tracks)) {
+		for _, segment := range splitSegments(tracks[rider], maxGap) {
+			line := make(orb.LineString, 0, len(segment))
+			for _, wp := range segment {
+				line = append(line, orb.Point{wp.Location.Longitude, wp.Location.Latitude})
+			}
+			lines = append(lines, tileLine{rider: rider, line: line})
+		}
+	}
+
+	return lines
+}
+
+// Track lines visible to the requester. Every map view requests dozens of
+// tiles, so the lines of all riders are kept until the waypoints change,
+// only a filter by rider computes them anew.
+func (app *App) visibleTileLines(r *http.Request) []tileLine {
+	if r.URL.Query().Has("rider") {
+		return riderTileLines(app.visibleWaypoints(r), app.config.SegmentGap)
+	}
+
+	restricted := !app.hasAccess(r)
+	app.wpMutex.RLock()
+	cached := app.tileLines
+	if restricted {
+		cached = app.restrictedTileLines
+	}
+	modified := app.tracksModified
+	app.wpMutex.RUnlock()
+	if cached != nil {
+		return cached
+	}
+
+	waypoints := app.snapshotWaypoints()
+	if restricted {
+		waypoints = restrictRiders(waypoints, app.config.Restriction, app.config.AnonRadiusKm)
+	}
+	lines := riderTileLines(waypoints, app.config.SegmentGap)
+
+	app.wpMutex.Lock()
+	defer app.wpMutex.Unlock()
+
+	// Don't cache lines of tracks that changed in the meantime
+	if !app.tracksModified.Equal(modified) {
+		return lines
+	}
+	if restricted {
+		app.restrictedTileLines = lines
+	} else {
+		app.tileLines = lines
+	}
+	return lines
+}
+
+// Parts of a line passing through the bound. A part is a run of points
+// where every connection between neighbors may cross the bound, so lines
+// entering and leaving it are kept up to their next point outside.
+func linesWithin(line orb.LineString, bound orb.Bound) []orb.LineString {
+	parts := make([]orb.LineString, 0)
+	var part orb.LineString
+	for i := 1; i < len(line); i++ {
+		a, b := line[i-1], line[i]
+		connection := orb.Bound{
+			Min: orb.Point{min(a[0], b[0]), min(a[1], b[1])},
+			Max: orb.Point{max(a[0], b[0]), max(a[1], b[1])},
+		}
+		if !connection.Intersects(bound) {
+			if len(part) > 0 {
+				parts = append(parts, part)
+				part = nil
+			}
+			continue
+		}
+
+		if len(part) == 0 {
+			part = append(part, a)
+		}
+		part = append(part, b)
+	}
+
+	if len(part) > 0 {
+		parts = append(parts, part)
+	}
+
+	return parts
+}
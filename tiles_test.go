@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/encoding/mvt"
+	"github.com/paulmach/orb/maptile"
+)
+
+// Fetch and decode a tile with access to the full track
+func fetchTile(t *testing.T, app *App, tile maptile.Tile) mvt.Layers {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodGet, "/tiles/?code=secret", nil)
+	req.SetPathValue("z", fmt.Sprint(tile.Z))
+	req.SetPathValue("x", fmt.Sprint(tile.X))
+	req.SetPathValue("y", fmt.Sprintf("%d.mvt", tile.Y))
+	rec := httptest.NewRecorder()
+	app.handleTile(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d, want 200", rec.Code)
+	}
+
+	layers, err := mvt.Unmarshal(rec.Body.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return layers
+}
+
+func TestTileClipsTrack(t *testing.T) {
+	app := testApp(t.TempDir())
+	app.config.SegmentGap = time.Hour
+	app.codes["secret"] = time.Time{}
+
+	// Heading east across several zoom 10 tiles, each about 0.35° wide
+	for i := range 31 {
+		app.waypoints = append(app.waypoints, testWaypoint(48.1372, 10.0+float64(i)*0.1, time.Duration(i)*time.Minute))
+	}
+
+	tile := maptile.At(orb.Point{11.5756, 48.1372}, 10)
+	layers := fetchTile(t, app, tile)
+	if len(layers) != 1 || layers[0].Name != "track" || len(layers[0].Features) != 1 {
+		t.Fatalf("got %d layers, want a track layer with one line", len(layers))
+	}
+
+	line, ok := layers[0].Features[0].Geometry.(orb.LineString)
+	if !ok {
+		t.Fatalf("geometry is %T, want a line", layers[0].Features[0].Geometry)
+	}
+	bound := mvt.MapboxGLDefaultExtentBound
+	for _, point := range line {
+		if !bound.Contains(point) {
+			t.Errorf("point %v outside the tile bound %v", point, bound)
+		}
+	}
+	// Both ends are cut at the left and right edge of the tile buffer, which
+	// reaches a tile width beyond the tile
+	if first, last := line[0], line[len(line)-1]; first[0] != bound.Min[0] || last[0] != bound.Max[0] {
+		t.Errorf("line runs from %v to %v, want it clipped at x = %v and %v", first, last, bound.Min[0], bound.Max[0])
+	}
+
+	// A tile away from the track has no lines
+	for _, layer := range fetchTile(t, app, maptile.New(tile.X, tile.Y+3, tile.Z)) {
+		if len(layer.Features) != 0 {
+			t.Errorf("got %d lines off the track, want none", len(layer.Features))
+		}
+	}
+}
+
+func TestLinesWithin(t *testing.T) {
+	bound := orb.Bound{Min: orb.Point{11, 47}, Max: orb.Point{12, 48}}
+
+	tests := []struct {
+		name string
+		line orb.LineString
+		want []int
+	}{
+		{"outside", orb.LineString{{10, 45}, {11, 45}}, []int{}},
+		{"inside", orb.LineString{{11.2, 47.2}, {11.4, 47.4}}, []int{2}},
+		// Both ends are far away, but the connection crosses the bound
+		{"crossing", orb.LineString{{10, 47.5}, {13, 47.5}}, []int{2}},
+		// In, out far to the west and back in, the points outside next to
+		// the bound are kept so the lines reach its edge
+		{"leaving and returning", orb.LineString{{11.5, 47.5}, {10.5, 47.5}, {9, 47.5}, {10.5, 47.5}, {11.5, 47.5}}, []int{2, 2}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lines := linesWithin(tt.line, bound)
+			got := make([]int, 0, len(lines))
+			for _, line := range lines {
+				got = append(got, len(line))
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got lines of %v points, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func BenchmarkTile(b *testing.B) {
+	app := testApp(b.TempDir())
+	app.config.SegmentGap = time.Hour
+	app.codes["secret"] = time.Time{}
+	app.waypoints = syntheticTrack(100_000)
+
+	req := httptest.NewRequest(http.MethodGet, "/tiles/?code=secret", nil)
+	tile := maptile.At(orb.Point{11.5, 47.5}, 14)
+	req.SetPathValue("z", fmt.Sprint(tile.Z))
+	req.SetPathValue("x", fmt.Sprint(tile.X))
+	req.SetPathValue("y", fmt.Sprintf("%d.mvt", tile.Y))
+	for b.Loop() {
+		app.handleTile(httptest.NewRecorder(), req)
+	}
+}
+
+func TestTileLinesFollowNewWaypoints(t *testing.T) {
+	app := testApp(t.TempDir())
+	app.config.SegmentGap = time.Hour
+	app.config.AnonRadiusKm = 1
+	app.codes["secret"] = time.Time{}
+	for i := range 10 {
+		app.recordWaypoint(testWaypoint(48.1, 11.0+float64(i)*0.01, time.Duration(i)*time.Minute))
+	}
+
+	tile := maptile.At(orb.Point{11.05, 48.1}, 12)
+	before := len(app.visibleTileLines(httptest.NewRequest(http.MethodGet, "/?code=secret", nil))[0].line)
+	anonymous := len(app.visibleTileLines(httptest.NewRequest(http.MethodGet, "/", nil))[0].line)
+	if before != 10 || anonymous >= before {
+		t.Fatalf("lines of %d points with a code and %d without, want 10 and fewer", before, anonymous)
+	}
+
+	app.recordWaypoint(testWaypoint(48.1, 11.1, 10*time.Minute))
+	if after := len(app.visibleTileLines(httptest.NewRequest(http.MethodGet, "/?code=secret", nil))[0].line); after != 11 {
+		t.Errorf("line has %d points after recording one more, want 11", after)
+	}
+	if layers := fetchTile(t, app, tile); len(layers) != 1 || len(layers[0].Features) != 1 {
+		t.Errorf("tile has %d layers, want the track", len(layers))
+	}
+}
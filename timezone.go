@@ -0,0 +1,75 @@
+package main
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+	_ "time/tzdata"
+
+	"github.com/ringsaturn/tzf"
+)
+
+// IANA timezone used when detection is disabled or fails, defaults to UTC
+const timezoneEnv = "TOURMAP_TIMEZONE"
+
+// Whether to detect the timezone from the current coordinates, true or
+// false
+const detectTimezoneEnv = "TOURMAP_DETECT_TIMEZONE"
+
+// Resolves the local timezone for coordinates
+type TimezoneResolver struct {
+	fallback  *time.Location
+	detect    bool
+	finder    tzf.F
+	finderErr error
+	once      sync.Once
+	locations sync.Map
+}
+
+// Create a timezone resolver, detection is only done if enabled
+func newTimezoneResolver(fallback *time.Location, detect bool) *TimezoneResolver {
+	return &TimezoneResolver{
+		fallback: fallback,
+		detect:   detect,
+	}
+}
+
+// Timezone at the given coordinates, or the fallback if it cannot be detected
+func (tz *TimezoneResolver) locate(coords *GPSCoords) *time.Location {
+	if tz == nil {
+		return time.UTC
+	}
+
+	if !tz.detect || coords == nil {
+		return tz.fallback
+	}
+
+	// The boundary data is large, only load it once it's actually needed
+	tz.once.Do(func() {
+		tz.finder, tz.finderErr = tzf.NewDefaultFinder()
+		if tz.finderErr != nil {
+			slog.Error("Error loading timezone boundaries", "fallback", tz.fallback.String(), "error", tz.finderErr)
+		}
+	})
+	if tz.finder == nil {
+		return tz.fallback
+	}
+
+	name := tz.finder.GetTimezoneName(coords.Longitude, coords.Latitude)
+	if name == "" {
+		return tz.fallback
+	}
+
+	if loc, ok := tz.locations.Load(name); ok {
+		return loc.(*time.Location)
+	}
+
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		slog.Error("Error loading timezone", "timezone", name, "error", err)
+		return tz.fallback
+	}
+	tz.locations.Store(name, loc)
+
+	return loc
+}
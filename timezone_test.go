@@ -0,0 +1,36 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTimezoneResolverLocate(t *testing.T) {
+	fallback := time.FixedZone("fallback", 0)
+	tz := newTimezoneResolver(fallback, true)
+
+	tests := []struct {
+		name   string
+		coords *GPSCoords
+		want   string
+	}{
+		{"Munich", &GPSCoords{Latitude: 48.1372, Longitude: 11.5756}, "Europe/Berlin"},
+		{"Vienna", &GPSCoords{Latitude: 48.2082, Longitude: 16.3738}, "Europe/Vienna"},
+		{"New York", &GPSCoords{Latitude: 40.7128, Longitude: -74.0060}, "America/New_York"},
+		{"Tokyo", &GPSCoords{Latitude: 35.6762, Longitude: 139.6503}, "Asia/Tokyo"},
+		{"no coordinates", nil, "fallback"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tz.locate(tt.coords).String(); got != tt.want {
+				t.Errorf("timezone = %s, want %s", got, tt.want)
+			}
+		})
+	}
+
+	disabled := newTimezoneResolver(fallback, false)
+	if got := disabled.locate(tests[0].coords); got != fallback {
+		t.Errorf("timezone without detection = %s, want the fallback", got)
+	}
+}
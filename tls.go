@@ -0,0 +1,34 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+const defaultAutocertCache = "./autocert"
+
+// Serve the handler on the configured address, over HTTPS if a certificate
+// or an autocert domain is configured
+func (cfg *Config) listenAndServe(handler http.Handler) error {
+	switch {
+	case cfg.AutocertDomain != "":
+		// Certificates are requested with the TLS-ALPN challenge, which needs
+		// the server to be reachable on port 443
+		manager := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(cfg.AutocertDomain),
+			Cache:      autocert.DirCache(cfg.AutocertCache),
+		}
+		server := &http.Server{Addr: cfg.Addr, Handler: handler, TLSConfig: manager.TLSConfig()}
+		slog.Info("Server starting", "addr", cfg.Addr, "mode", "autocert", "domain", cfg.AutocertDomain)
+		return server.ListenAndServeTLS("", "")
+	case cfg.TLSCert != "":
+		slog.Info("Server starting", "addr", cfg.Addr, "mode", "tls", "cert", cfg.TLSCert)
+		return http.ListenAndServeTLS(cfg.Addr, cfg.TLSCert, cfg.TLSKey, handler)
+	default:
+		slog.Info("Server starting", "addr", cfg.Addr, "mode", "http")
+		return http.ListenAndServe(cfg.Addr, handler)
+	}
+}
@@ -0,0 +1,325 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// Placeholder replaced with the tracking token in URL and body templates
+const tokenPlaceholder = "{token}"
+
+const defaultTrackingURL = "https://dashboard.hammerhead.io/v1/shares/tracking/" + tokenPlaceholder
+
+// How to call the tracking provider
+type TrackingRequest struct {
+	Method  string
+	URL     string
+	Headers http.Header
+	Body    string
+}
+
+// Load the tracking request settings for the URL template from the
+// environment
+//
+//	TOURMAP_TRACKING_METHOD   HTTP method, defaults to GET
+//	TOURMAP_TRACKING_HEADERS  semicolon separated "Name: value" pairs
+//	TOURMAP_TRACKING_BODY     request body template
+func trackingRequestFromEnv(urlTemplate string) (TrackingRequest, error) {
+	req := TrackingRequest{
+		Method:  strings.ToUpper(strings.TrimSpace(os.Getenv("TOURMAP_TRACKING_METHOD"))),
+		URL:     strings.TrimSpace(urlTemplate),
+		Headers: make(http.Header),
+		Body:    os.Getenv("TOURMAP_TRACKING_BODY"),
+	}
+
+	if req.Method == "" {
+		req.Method = http.MethodGet
+	}
+
+	if req.URL == "" {
+		req.URL = defaultTrackingURL
+	}
+
+	for _, header := range strings.Split(os.Getenv("TOURMAP_TRACKING_HEADERS"), ";") {
+		if strings.TrimSpace(header) == "" {
+			continue
+		}
+
+		name, value, ok := strings.Cut(header, ":")
+		if !ok || strings.TrimSpace(name) == "" {
+			return req, fmt.Errorf("invalid header %q, expected \"Name: value\"", header)
+		}
+		req.Headers.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+
+	// Check the templates produce a valid request
+	if _, err := req.build("token"); err != nil {
+		return req, err
+	}
+
+	parsed, err := url.Parse(strings.ReplaceAll(req.URL, tokenPlaceholder, "token"))
+	if err != nil {
+		return req, err
+	} else if parsed.Scheme != "http" && parsed.Scheme != "https" {
+		return req, fmt.Errorf("URL %q must be http or https", req.URL)
+	}
+
+	return req, nil
+}
+
+// A tracking token and the rider it belongs to
+type trackingSource struct {
+	Rider string
+	Token string
+}
+
+// Content of the tracking token file, or TOURMAP_TRACKING_TOKEN with one
+// token per comma or line if set
+func readTrackingTokens() ([]byte, error) {
+	if value := os.Getenv(trackingTokenEnv); value != "" {
+		return []byte(strings.ReplaceAll(value, ",", "\n")), nil
+	}
+
+	return os.ReadFile(trackingTokenFile)
+}
+
+// Parse the tracking token file. Every non-empty line holds a token,
+// optionally preceded by a rider name and whitespace as "name token". Tokens
+// are never split at other characters, as they may contain any of them.
+// Unnamed tokens belong to the default rider, so adding or removing lines
+// doesn't rename anyone. Lines with more than two fields are skipped.
+func parseTrackingTokens(content string) []trackingSource {
+	sources := make([]trackingSource, 0)
+	for _, line := range strings.Split(content, "\n") {
+		switch fields := strings.Fields(line); len(fields) {
+		case 0:
+			continue
+		case 1:
+			sources = append(sources, trackingSource{Token: fields[0]})
+		case 2:
+			sources = append(sources, trackingSource{Rider: fields[0], Token: fields[1]})
+		default:
+			slog.Warn("Skipping tracking token line, expected a token or \"name token\"", "field_count", len(fields))
+		}
+	}
+
+	return sources
+}
+
+// Tracking tokens of the waypoint scan, carried from one tick to the next
+type tokenState struct {
+	// Tokens currently listed, true once the provider reported them gone
+	deleted     map[string]bool
+	fileMissing bool
+	fileEmpty   bool
+}
+
+func newTokenState() *tokenState {
+	return &tokenState{deleted: make(map[string]bool)}
+}
+
+// Tokens to fetch for the content of the token file. Tokens the provider
+// reported gone are skipped while they stay listed, removing them or
+// clearing the file makes them count as new again. A missing file is
+// treated like an empty one, both are only logged once.
+func (s *tokenState) update(data []byte, err error) []trackingSource {
+	if errors.Is(err, fs.ErrNotExist) {
+		if !s.fileMissing {
+			slog.Info("Tracking token file does not exist, waiting for it", "path", trackingTokenFile)
+			s.fileMissing = true
+		}
+		s.deleted = make(map[string]bool)
+		return nil
+	} else if err != nil {
+		slog.Error("Error reading tracking token file", "path", trackingTokenFile, "error", err)
+		return nil
+	}
+	s.fileMissing = false
+
+	sources := parseTrackingTokens(string(data))
+	if len(sources) == 0 {
+		if !s.fileEmpty {
+			slog.Info("Tracking token file is empty", "path", trackingTokenFile)
+			s.fileEmpty = true
+		}
+		s.deleted = make(map[string]bool)
+		return nil
+	}
+	s.fileEmpty = false
+
+	// Only remember tokens still listed in the file
+	current := make(map[string]bool, len(sources))
+	active := make([]trackingSource, 0, len(sources))
+	for _, source := range sources {
+		isDeleted, known := s.deleted[source.Token]
+		if !known {
+			slog.Info("Using new tracking token", "rider", source.Rider, "token_hash", tokenHash(source.Token))
+		}
+		current[source.Token] = isDeleted
+		if !isDeleted {
+			active = append(active, source)
+		}
+	}
+	s.deleted = current
+
+	return active
+}
+
+// Short hash identifying a token in logs, tokens grant access to the live
+// position and must not end up in plaintext
+func tokenHash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:4])
+}
+
+// Stop fetching a token the provider reported gone
+func (s *tokenState) markDeleted(token string) {
+	s.deleted[token] = true
+}
+
+var errTokenNotFound = errors.New("tracking token not found")
+
+// Unexpected HTTP status of the tracking provider
+type statusError struct {
+	StatusCode int
+	Status     string
+}
+
+func (err *statusError) Error() string {
+	return fmt.Sprintf("non-OK HTTP status: %s", err.Status)
+}
+
+// Attempts per token and tick, failures other than a 404 are retried
+const fetchAttempts = 3
+
+// Delay before the first retry, doubled for every further one. A variable
+// so tests don't have to wait.
+var fetchRetryDelay = time.Second
+
+// Source of the current position of a tracked device. Implementations
+// return errTokenNotFound once the provider no longer knows the device, so
+// it isn't asked again.
+type TrackingProvider interface {
+	Fetch(ctx context.Context) (Waypoint, error)
+}
+
+// Provider for the device shared with the tracking token
+func (app *App) trackingProvider(token string) TrackingProvider {
+	return &hammerheadProvider{request: app.config.Tracking, token: token, client: app.client}
+}
+
+// Fetch the latest waypoint, retrying transient failures with exponential
+// backoff. A 404 is returned right away, as is the context's error once it
+// is done.
+func fetchWaypointWithRetry(ctx context.Context, provider TrackingProvider) (Waypoint, error) {
+	delay := fetchRetryDelay
+	for attempt := 1; ; attempt++ {
+		wp, err := provider.Fetch(ctx)
+		if err == nil || errors.Is(err, errTokenNotFound) || attempt == fetchAttempts {
+			return wp, err
+		}
+
+		slog.Debug("Retrying tracking fetch", "attempt", attempt, "status", fetchStatus(err), "error", err)
+		select {
+		case <-ctx.Done():
+			return wp, ctx.Err()
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+// HTTP status of a failed fetch, 0 if the request didn't get a response
+func fetchStatus(err error) int {
+	if errors.Is(err, errTokenNotFound) {
+		return http.StatusNotFound
+	}
+
+	var status *statusError
+	if errors.As(err, &status) {
+		return status.StatusCode
+	}
+
+	return 0
+}
+
+// Hammerhead Karoo live tracking share, the response is a Waypoint. Other
+// services answering in the same format work with a custom request.
+type hammerheadProvider struct {
+	request TrackingRequest
+	token   string
+	client  *http.Client
+}
+
+// Fetch the current position shared with the token. The location is nil if
+// the device has no position yet.
+func (p *hammerheadProvider) Fetch(ctx context.Context) (Waypoint, error) {
+	req, err := p.request.build(p.token)
+	if err != nil {
+		return Waypoint{}, fmt.Errorf("building request: %w", p.redact(err))
+	}
+
+	resp, err := p.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return Waypoint{}, p.redact(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return Waypoint{}, errTokenNotFound
+	} else if resp.StatusCode != http.StatusOK {
+		return Waypoint{}, &statusError{StatusCode: resp.StatusCode, Status: resp.Status}
+	}
+
+	var wp Waypoint
+	if err := json.NewDecoder(resp.Body).Decode(&wp); err != nil {
+		return Waypoint{}, fmt.Errorf("decoding JSON: %w", err)
+	}
+	wp.Timestamp = wp.Timestamp.UTC()
+
+	return wp, nil
+}
+
+// Replace the URL in request errors with the template, as the URL may
+// contain the token
+func (p *hammerheadProvider) redact(err error) error {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		urlErr.URL = p.request.URL
+	}
+
+	return err
+}
+
+// Build the HTTP request for the given token
+func (tr TrackingRequest) build(token string) (*http.Request, error) {
+	var body io.Reader
+	if tr.Body != "" {
+		body = strings.NewReader(strings.ReplaceAll(tr.Body, tokenPlaceholder, token))
+	}
+
+	req, err := http.NewRequest(tr.Method, strings.ReplaceAll(tr.URL, tokenPlaceholder, token), body)
+	if err != nil {
+		return nil, err
+	}
+
+	for name, values := range tr.Headers {
+		for _, value := range values {
+			req.Header.Add(name, strings.ReplaceAll(value, tokenPlaceholder, token))
+		}
+	}
+
+	return req, nil
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestTokenStateTransitions(t *testing.T) {
+	// A tick reads the file, fetches the returned tokens and the provider
+	// answers 404 for the tokens in notFound
+	type tick struct {
+		content  string
+		missing  bool
+		notFound []string
+		want     []string
+	}
+
+	tests := []struct {
+		name  string
+		ticks []tick
+	}{
+		{"empty to token", []tick{
+			{content: "", want: nil},
+			{content: "abc", want: []string{"abc"}},
+		}},
+		{"missing to token", []tick{
+			{missing: true, want: nil},
+			{content: "abc", want: []string{"abc"}},
+		}},
+		{"token to same token", []tick{
+			{content: "abc", want: []string{"abc"}},
+			{content: "abc\n", want: []string{"abc"}},
+		}},
+		{"token to 404 to same token", []tick{
+			{content: "abc", notFound: []string{"abc"}, want: []string{"abc"}},
+			{content: "abc", want: nil},
+			{content: "abc", want: nil},
+		}},
+		{"token to 404 to cleared to same token", []tick{
+			{content: "abc", notFound: []string{"abc"}, want: []string{"abc"}},
+			{content: "", want: nil},
+			{content: "abc", want: []string{"abc"}},
+		}},
+		{"token to 404 to removed to same token", []tick{
+			{content: "abc\ndef", notFound: []string{"abc"}, want: []string{"abc", "def"}},
+			{content: "def", want: []string{"def"}},
+			{content: "abc\ndef", want: []string{"abc", "def"}},
+		}},
+		{"404 of one token keeps the others", []tick{
+			{content: "anna abc\nben def", notFound: []string{"def"}, want: []string{"abc", "def"}},
+			{content: "anna abc\nben def", want: []string{"abc"}},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			state := newTokenState()
+			for i, tick := range tt.ticks {
+				var err error
+				if tick.missing {
+					err = fs.ErrNotExist
+				}
+
+				var got []string
+				for _, source := range state.update([]byte(tick.content), err) {
+					got = append(got, source.Token)
+					if slices.Contains(tick.notFound, source.Token) {
+						state.markDeleted(source.Token)
+					}
+				}
+
+				if !slices.Equal(got, tick.want) {
+					t.Errorf("tick %d fetched %q, want %q", i, got, tick.want)
+				}
+			}
+		})
+	}
+}
+
+func TestTrackingRequestPOST(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if r.Method != http.MethodPost || r.URL.Path != "/track/abc" {
+			t.Errorf("got %s %s, want POST /track/abc", r.Method, r.URL.Path)
+		}
+		if got := string(body); got != `{"share":"abc"}` {
+			t.Errorf("body = %s, want the token filled in", got)
+		}
+		if got := r.Header.Get("Authorization"); got != "Bearer abc" {
+			t.Errorf("Authorization = %q, want Bearer abc", got)
+		}
+		if got := r.Header.Get("Content-Type"); got != "application/json" {
+			t.Errorf("Content-Type = %q, want application/json", got)
+		}
+
+		w.Write([]byte(`{"location":{"lat":47.1,"lng":11.2},"updatedAt":"2024-06-01T10:00:00+02:00"}`))
+	}))
+	defer server.Close()
+
+	t.Setenv("TOURMAP_TRACKING_METHOD", "post")
+	t.Setenv("TOURMAP_TRACKING_HEADERS", "Authorization: Bearer {token}; Content-Type: application/json")
+	t.Setenv("TOURMAP_TRACKING_BODY", `{"share":"{token}"}`)
+	request, err := trackingRequestFromEnv(server.URL + "/track/{token}")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	provider := &hammerheadProvider{request: request, token: "abc", client: server.Client()}
+	wp, err := provider.Fetch(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wp.Location == nil || wp.Location.Latitude != 47.1 || !wp.Timestamp.Equal(time.Date(2024, 6, 1, 8, 0, 0, 0, time.UTC)) {
+		t.Errorf("waypoint = %+v at %v, want 47.1, 11.2 at 08:00 UTC", wp.Location, wp.Timestamp)
+	}
+}
+
+func TestTrackingRequestInvalidHeader(t *testing.T) {
+	t.Setenv("TOURMAP_TRACKING_HEADERS", "Authorization Bearer {token}")
+	if _, err := trackingRequestFromEnv(""); err == nil {
+		t.Error("header without colon accepted")
+	}
+}
+
+func TestTokenStateLogsMissingFileOnce(t *testing.T) {
+	var logs bytes.Buffer
+	defaultLogger := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logs, nil)))
+	t.Cleanup(func() { slog.SetDefault(defaultLogger) })
+
+	state := newTokenState()
+	for range 3 {
+		state.update(nil, fs.ErrNotExist)
+	}
+	if count := strings.Count(logs.String(), "does not exist"); count != 1 {
+		t.Errorf("logged the missing file %d times, want once", count)
+	}
+
+	// Once the file showed up, going missing again is logged again
+	state.update([]byte("abc"), nil)
+	state.update(nil, fs.ErrNotExist)
+	state.update(nil, fs.ErrNotExist)
+	if count := strings.Count(logs.String(), "does not exist"); count != 2 {
+		t.Errorf("logged the missing file %d times, want twice", count)
+	}
+}
+
+// Retry without waiting a second per attempt
+func fastRetries(t *testing.T) {
+	defaultDelay := fetchRetryDelay
+	fetchRetryDelay = time.Millisecond
+	t.Cleanup(func() { fetchRetryDelay = defaultDelay })
+}
+
+func TestFetchWaypointWithRetry(t *testing.T) {
+	fastRetries(t)
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		count := requests.Add(1)
+		switch {
+		case r.URL.Path == "/gone":
+			http.NotFound(w, r)
+		case count == 1:
+			http.Error(w, "busy", http.StatusServiceUnavailable)
+		default:
+			w.Write([]byte(`{"location":{"lat":47.1,"lng":11.2},"updatedAt":"2024-06-01T08:00:00Z"}`))
+		}
+	}))
+	defer server.Close()
+
+	provider := func(token string) *hammerheadProvider {
+		request := TrackingRequest{Method: http.MethodGet, URL: server.URL + "/" + tokenPlaceholder}
+		return &hammerheadProvider{request: request, token: token, client: server.Client()}
+	}
+
+	wp, err := fetchWaypointWithRetry(context.Background(), provider("abc"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if requests.Load() != 2 || wp.Location == nil {
+		t.Errorf("got %+v after %d requests, want the waypoint after 2", wp.Location, requests.Load())
+	}
+
+	// A 404 means the token is gone, asking again won't help
+	requests.Store(0)
+	if _, err := fetchWaypointWithRetry(context.Background(), provider("gone")); !errors.Is(err, errTokenNotFound) || requests.Load() != 1 {
+		t.Errorf("got %v after %d requests, want errTokenNotFound after 1", err, requests.Load())
+	}
+}
+
+func TestFetchRetryCanceled(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		http.Error(w, "busy", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	request := TrackingRequest{Method: http.MethodGet, URL: server.URL + "/" + tokenPlaceholder}
+	provider := &hammerheadProvider{request: request, token: "abc", client: server.Client()}
+
+	// Canceled while waiting for the first retry, which is far off
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := fetchWaypointWithRetry(ctx, provider)
+	if !errors.Is(err, context.DeadlineExceeded) || requests.Load() != 1 {
+		t.Errorf("got %v after %d requests, want the context's error after 1", err, requests.Load())
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("gave up after %v, want the context deadline", elapsed)
+	}
+}
+
+func TestFetchTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer server.Close()
+
+	client := server.Client()
+	client.Timeout = 50 * time.Millisecond
+	request := TrackingRequest{Method: http.MethodGet, URL: server.URL + "/" + tokenPlaceholder}
+	provider := &hammerheadProvider{request: request, token: "abc", client: client}
+
+	start := time.Now()
+	_, err := provider.Fetch(context.Background())
+	if err == nil {
+		t.Fatal("slow server didn't time out")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("gave up after %v, want the client timeout", elapsed)
+	}
+	if status := fetchStatus(err); status != 0 {
+		t.Errorf("status of the timeout = %d, want 0", status)
+	}
+}
+
+func TestTokensNotLogged(t *testing.T) {
+	const token = "s3cr3t-token"
+
+	var logs bytes.Buffer
+	defaultLogger := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logs, nil)))
+	t.Cleanup(func() { slog.SetDefault(defaultLogger) })
+
+	newTokenState().update([]byte("alice "+token), nil)
+	if strings.Contains(logs.String(), token) || !strings.Contains(logs.String(), tokenHash(token)) {
+		t.Errorf("log = %q, want the token hash only", logs.String())
+	}
+
+	// Errors of the request carry the URL, which contains the token
+	request := TrackingRequest{Method: http.MethodGet, URL: "http://127.0.0.1:0/" + tokenPlaceholder}
+	provider := &hammerheadProvider{request: request, token: token, client: http.DefaultClient}
+	if _, err := provider.Fetch(context.Background()); err == nil || strings.Contains(err.Error(), token) {
+		t.Errorf("error = %v, want one without the token", err)
+	}
+}
@@ -0,0 +1,572 @@
+package main
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"maps"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tormoder/fit"
+)
+
+const defaultGpxDir = "./gpx"
+const defaultTcxDir = "./tcx"
+const defaultFitDir = "./fit"
+
+// Whether to drop imported tracks that duplicate another imported track,
+// true or false
+const mergeOverlappingEnv = "TOURMAP_MERGE_OVERLAPPING"
+
+// Interval for checking the import directories for new track files
+const trackScanInterval = 60 * time.Second
+
+// Parsed track file
+type trackImport struct {
+	modTime time.Time
+	track   []Waypoint
+	// Set while the track duplicates another import and is left out
+	dropped bool
+	// Set once the track is part of the waypoints
+	merged bool
+}
+
+// Parse the track files with the given extension in dir that are new or
+// changed since they were recorded in imports, and forget the ones that were
+// removed. Returns the paths of the parsed files and whether a file already
+// part of the waypoints changed or was removed. A missing directory is not
+// an error as track imports are optional.
+func loadTrackFiles(dir, ext string, parse func(path string) ([]Waypoint, error), imports map[string]*trackImport) (parsed []string, stale bool) {
+	found := make(map[string]bool)
+	count := 0
+
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if d.IsDir() || !strings.EqualFold(filepath.Ext(path), ext) {
+			return nil
+		}
+		found[path] = true
+
+		info, err := d.Info()
+		if err != nil {
+			slog.Error("Error reading track file info", "path", path, "error", err)
+			return nil
+		}
+
+		previous, ok := imports[path]
+		if ok && previous.modTime.Equal(info.ModTime()) {
+			return nil
+		}
+		stale = stale || (ok && previous.merged)
+
+		// Broken files are recorded as well, so they are only parsed again
+		// once they change
+		imports[path] = &trackImport{modTime: info.ModTime()}
+		track, err := parse(path)
+		if err != nil {
+			slog.Error("Error parsing track file", "path", path, "error", err)
+			return nil
+		}
+
+		slices.SortFunc(track, func(a, b Waypoint) int {
+			return a.Timestamp.Compare(b.Timestamp)
+		})
+		imports[path].track = track
+		parsed = append(parsed, path)
+		count += len(track)
+		return nil
+	})
+
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		slog.Error("Error walking track directory", "path", dir, "error", err)
+		return parsed, stale
+	}
+
+	for path, imported := range imports {
+		rel, err := filepath.Rel(dir, path)
+		if err != nil || strings.HasPrefix(rel, "..") || !strings.EqualFold(filepath.Ext(path), ext) || found[path] {
+			continue
+		}
+
+		slog.Info("Track file removed", "path", path)
+		stale = stale || imported.merged
+		delete(imports, path)
+	}
+
+	if len(parsed) > 0 {
+		slog.Info("Loaded track files", "path", dir, "extension", ext, "file_count", len(parsed), "waypoint_count", count)
+	}
+
+	return parsed, stale
+}
+
+// Load the track files that are new or changed since the last call and mark
+// imports duplicating another one, comparing against all loaded imports.
+// Returns the tracks to add to the waypoints. rebuild is set instead if an
+// import that is already part of them changed, was removed or turned out to
+// duplicate a new one, as its waypoints can't be taken out again.
+func (app *App) loadTrackImports() (added [][]Waypoint, rebuild bool) {
+	app.trackMutex.Lock()
+	defer app.trackMutex.Unlock()
+
+	gpxParsed, gpxStale := loadTrackFiles(app.config.GpxDir, ".gpx", parseGpxFile, app.trackFiles)
+	tcxParsed, tcxStale := loadTrackFiles(app.config.TcxDir, ".tcx", parseTcxFile, app.trackFiles)
+	fitParsed, fitStale := loadTrackFiles(app.config.FitDir, ".fit", parseFitFile, app.trackFiles)
+	rebuild = gpxStale || tcxStale || fitStale
+	for _, path := range slices.Concat(gpxParsed, tcxParsed, fitParsed) {
+		app.trackFiles[path].track = smoothWaypoints(app.trackFiles[path].track, app.config.SmoothWindow)
+	}
+
+	paths := slices.Sorted(maps.Keys(app.trackFiles))
+	if app.config.MergeOverlapping {
+		tracks := make([][]Waypoint, len(paths))
+		for i, path := range paths {
+			tracks[i] = app.trackFiles[path].track
+		}
+
+		for i, dropped := range duplicateTracks(tracks) {
+			imported := app.trackFiles[paths[i]]
+			if dropped && !imported.dropped {
+				slog.Info("Dropping overlapping track", "path", paths[i])
+			}
+			rebuild = rebuild || (dropped && imported.merged)
+			imported.dropped = dropped
+		}
+	}
+
+	for _, path := range paths {
+		imported := app.trackFiles[path]
+		if !imported.dropped && !imported.merged && len(imported.track) > 0 {
+			added = append(added, imported.track)
+			imported.merged = true
+		}
+	}
+
+	return added, rebuild
+}
+
+// All imported tracks not dropped as duplicates, which are part of the
+// waypoints from now on
+func (app *App) importedTracks() [][]Waypoint {
+	app.trackMutex.Lock()
+	defer app.trackMutex.Unlock()
+
+	tracks := make([][]Waypoint, 0, len(app.trackFiles))
+	for _, path := range slices.Sorted(maps.Keys(app.trackFiles)) {
+		imported := app.trackFiles[path]
+		imported.merged = !imported.dropped
+		if imported.merged {
+			tracks = append(tracks, imported.track)
+		}
+	}
+
+	return tracks
+}
+
+// Periodically import new or changed track files
+func (app *App) periodicTrackScan() {
+	ticker := time.NewTicker(trackScanInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		app.importTrackFiles()
+	}
+}
+
+// Append waypoints of new track files, without parsing the already loaded
+// ones again. Changed, removed or duplicate imports need all waypoints to be
+// loaded again.
+func (app *App) importTrackFiles() {
+	tracks, rebuild := app.loadTrackImports()
+	if rebuild {
+		slog.Info("Imported track files changed, reloading waypoints")
+		app.loadWaypoints()
+		return
+	}
+
+	added := make([]Waypoint, 0)
+	for _, track := range tracks {
+		added = append(added, track...)
+	}
+
+	if len(added) == 0 {
+		return
+	}
+
+	app.wpMutex.Lock()
+	defer app.wpMutex.Unlock()
+
+	app.waypoints = mergeWaypoints(app.waypoints, added, app.config.reduceTrack)
+	app.waypoints = capWaypoints(app.waypoints, app.config.MaxWaypoints)
+	for _, wp := range app.waypoints {
+		app.latestByRider[wp.Rider] = wp.Timestamp
+	}
+	app.resetTracksJSON()
+}
+
+// Merge waypoints into a time ordered track, dropping points recorded twice
+// and reducing every rider's track separately
+func mergeWaypoints(existing, added []Waypoint, reduce func([]Waypoint) []Waypoint) []Waypoint {
+	merged := make([]Waypoint, 0, len(existing)+len(added))
+	merged = append(merged, existing...)
+	merged = append(merged, added...)
+
+	slices.SortStableFunc(merged, func(a, b Waypoint) int {
+		return a.Timestamp.Compare(b.Timestamp)
+	})
+
+	tracks := groupByRider(merged)
+	merged = merged[:0]
+	for _, track := range tracks {
+		track = slices.CompactFunc(track, func(a, b Waypoint) bool {
+			return a.Timestamp.Equal(b.Timestamp)
+		})
+		merged = append(merged, reduce(track)...)
+	}
+
+	if len(tracks) > 1 {
+		slices.SortStableFunc(merged, func(a, b Waypoint) int {
+			return a.Timestamp.Compare(b.Timestamp)
+		})
+	}
+
+	return merged
+}
+
+// Mark imported tracks that duplicate another one, e.g. the same ride
+// exported by two apps. Two tracks overlap if most of the shorter one's time
+// range is covered by the other and its points lie close to the other track
+// at the same time. Of two overlapping tracks the one with more points per
+// hour is kept.
+func duplicateTracks(tracks [][]Waypoint) []bool {
+	const minTimeOverlap = 0.8
+	const minSpatialMatch = 0.8
+	const maxMatchDistanceKm = 0.1
+
+	dropped := make([]bool, len(tracks))
+	for i := range tracks {
+		for j := i + 1; j < len(tracks); j++ {
+			if dropped[i] || dropped[j] || len(tracks[i]) < 2 || len(tracks[j]) < 2 {
+				continue
+			}
+
+			// Compare the lower resolution track against the higher one
+			low, high := i, j
+			if trackResolution(tracks[i]) > trackResolution(tracks[j]) {
+				low, high = j, i
+			}
+
+			if timeOverlap(tracks[low], tracks[high]) < minTimeOverlap {
+				continue
+			}
+
+			if spatialMatch(tracks[low], tracks[high], maxMatchDistanceKm) < minSpatialMatch {
+				continue
+			}
+
+			dropped[low] = true
+		}
+	}
+
+	return dropped
+}
+
+// Points per hour of a time-sorted track
+func trackResolution(track []Waypoint) float64 {
+	hours := track[len(track)-1].Timestamp.Sub(track[0].Timestamp).Hours()
+	if hours <= 0 {
+		return math.Inf(1)
+	}
+
+	return float64(len(track)) / hours
+}
+
+// Fraction of the shorter track's time range covered by the other
+func timeOverlap(a, b []Waypoint) float64 {
+	aStart, aEnd := a[0].Timestamp, a[len(a)-1].Timestamp
+	bStart, bEnd := b[0].Timestamp, b[len(b)-1].Timestamp
+
+	start := aStart
+	if bStart.After(start) {
+		start = bStart
+	}
+	end := aEnd
+	if bEnd.Before(end) {
+		end = bEnd
+	}
+
+	shorter := min(aEnd.Sub(aStart), bEnd.Sub(bStart))
+	if shorter <= 0 {
+		if !end.Before(start) {
+			return 1
+		}
+		return 0
+	}
+
+	return max(end.Sub(start), 0).Seconds() / shorter.Seconds()
+}
+
+// Fraction of points in a lying within maxKm of the point of b closest in time
+func spatialMatch(a, b []Waypoint, maxKm float64) float64 {
+	matched := 0
+	for _, wp := range a {
+		i, _ := slices.BinarySearchFunc(b, wp.Timestamp, func(e Waypoint, t time.Time) int {
+			return e.Timestamp.Compare(t)
+		})
+
+		nearest := b[min(i, len(b)-1)]
+		if i > 0 && wp.Timestamp.Sub(b[i-1].Timestamp) < nearest.Timestamp.Sub(wp.Timestamp) {
+			nearest = b[i-1]
+		}
+
+		if distanceKm(wp.Location.Latitude, wp.Location.Longitude, nearest.Location.Latitude, nearest.Location.Longitude) <= maxKm {
+			matched++
+		}
+	}
+
+	return float64(matched) / float64(len(a))
+}
+
+// Parse the track points of a GPX file. Points without a valid time or
+// position are skipped.
+func parseGpxFile(path string) ([]Waypoint, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var doc gpxFile
+	if err := xml.NewDecoder(file).Decode(&doc); err != nil {
+		return nil, err
+	}
+
+	waypoints := make([]Waypoint, 0)
+	for _, track := range doc.Tracks {
+		for _, segment := range track.Segments {
+			for _, point := range segment.Points {
+				lat, errLat := strconv.ParseFloat(point.Lat, 64)
+				lon, errLon := strconv.ParseFloat(point.Lon, 64)
+				if errLat != nil || errLon != nil {
+					continue
+				}
+
+				timestamp, err := time.Parse(time.RFC3339, strings.TrimSpace(point.Time))
+				if err != nil {
+					continue
+				}
+
+				coords := GPSCoords{Latitude: lat, Longitude: lon, Elevation: parseElevation(point.Ele)}
+				if !validCoords(coords) {
+					slog.Warn("Skipping waypoint with invalid coordinates", "path", path, "lat", lat, "lng", lon)
+					continue
+				}
+
+				wp := Waypoint{Location: &coords, Timestamp: timestamp.UTC()}
+				if point.Extensions != nil {
+					wp.HeartRate = parseSensorValue(point.Extensions.HeartRate)
+					wp.Cadence = parseSensorValue(point.Extensions.Cadence)
+				}
+				waypoints = append(waypoints, wp)
+			}
+		}
+	}
+
+	return waypoints, nil
+}
+
+// Parse an optional heart rate or cadence. Empty, invalid and out of range
+// values yield nil, devices use 255 to mark missing readings.
+func parseSensorValue(value string) *int {
+	v, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil || v < 0 || v >= 255 {
+		return nil
+	}
+
+	return &v
+}
+
+// Parse an optional elevation, empty or invalid values yield nil
+func parseElevation(value string) *float64 {
+	ele, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil {
+		return nil
+	}
+
+	return &ele
+}
+
+// TCX document, only the parts needed for track points
+type tcxFile struct {
+	Activities []struct {
+		Laps []struct {
+			Trackpoints []tcxTrackpoint `xml:"Track>Trackpoint"`
+		} `xml:"Lap"`
+	} `xml:"Activities>Activity"`
+}
+
+type tcxTrackpoint struct {
+	Time      string `xml:"Time"`
+	Altitude  string `xml:"AltitudeMeters"`
+	HeartRate string `xml:"HeartRateBpm>Value"`
+	Cadence   string `xml:"Cadence"`
+	Position  *struct {
+		Lat string `xml:"LatitudeDegrees"`
+		Lon string `xml:"LongitudeDegrees"`
+	} `xml:"Position"`
+}
+
+// Parse the trackpoints of a TCX file. Trackpoints without a position, as
+// recorded during GPS dropouts, or without a valid time are skipped.
+func parseTcxFile(path string) ([]Waypoint, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var doc tcxFile
+	if err := xml.NewDecoder(file).Decode(&doc); err != nil {
+		return nil, err
+	}
+
+	waypoints := make([]Waypoint, 0)
+	for _, activity := range doc.Activities {
+		for _, lap := range activity.Laps {
+			for _, point := range lap.Trackpoints {
+				if point.Position == nil {
+					continue
+				}
+
+				lat, errLat := strconv.ParseFloat(strings.TrimSpace(point.Position.Lat), 64)
+				lon, errLon := strconv.ParseFloat(strings.TrimSpace(point.Position.Lon), 64)
+				if errLat != nil || errLon != nil {
+					continue
+				}
+
+				timestamp, err := time.Parse(time.RFC3339, strings.TrimSpace(point.Time))
+				if err != nil {
+					continue
+				}
+
+				coords := GPSCoords{Latitude: lat, Longitude: lon, Elevation: parseElevation(point.Altitude)}
+				if !validCoords(coords) {
+					slog.Warn("Skipping waypoint with invalid coordinates", "path", path, "lat", lat, "lng", lon)
+					continue
+				}
+
+				waypoints = append(waypoints, Waypoint{
+					Location:  &coords,
+					Timestamp: timestamp.UTC(),
+					HeartRate: parseSensorValue(point.HeartRate),
+					Cadence:   parseSensorValue(point.Cadence),
+				})
+			}
+		}
+	}
+
+	return waypoints, nil
+}
+
+// Parse the records of a FIT file. Activities are read across all their
+// sessions, courses as used for planned routes by their records. Files
+// chained one after another are read as one track. Records without a
+// position or timestamp are skipped.
+func parseFitFile(path string) ([]Waypoint, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	fitFiles, err := fit.DecodeChained(file)
+	if err != nil {
+		return nil, err
+	}
+
+	waypoints := make([]Waypoint, 0)
+	for _, fitFile := range fitFiles {
+		records, err := fitRecords(fitFile)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, record := range records {
+			if record.PositionLat.Invalid() || record.PositionLong.Invalid() || fit.IsBaseTime(record.Timestamp) {
+				continue
+			}
+
+			coords := GPSCoords{Latitude: record.PositionLat.Degrees(), Longitude: record.PositionLong.Degrees(), Elevation: fitAltitude(record)}
+			if !validCoords(coords) {
+				slog.Warn("Skipping waypoint with invalid coordinates", "path", path, "lat", coords.Latitude, "lng", coords.Longitude)
+				continue
+			}
+
+			waypoints = append(waypoints, Waypoint{
+				Location:  &coords,
+				Timestamp: record.Timestamp.UTC(),
+				HeartRate: fitSensorValue(record.HeartRate),
+				Cadence:   fitSensorValue(record.Cadence),
+			})
+		}
+	}
+
+	slices.SortStableFunc(waypoints, func(a, b Waypoint) int {
+		return a.Timestamp.Compare(b.Timestamp)
+	})
+
+	return waypoints, nil
+}
+
+// Records of a FIT file, of the activity or if it has none of the course
+func fitRecords(fitFile *fit.File) ([]*fit.RecordMsg, error) {
+	if activity, err := fitFile.Activity(); err == nil && len(activity.Records) > 0 {
+		return activity.Records, nil
+	}
+
+	if course, err := fitFile.Course(); err == nil {
+		return course.Records, nil
+	}
+
+	// Files of other types, like settings, carry no track
+	if fitFile.Type() != fit.FileTypeActivity {
+		return nil, fmt.Errorf("unsupported FIT file type %v", fitFile.Type())
+	}
+
+	return nil, nil
+}
+
+// Elevation of a FIT record, preferring the enhanced altitude with a larger
+// range. Nil if the record has none.
+func fitAltitude(record *fit.RecordMsg) *float64 {
+	altitude := record.GetEnhancedAltitudeScaled()
+	if math.IsNaN(altitude) {
+		altitude = record.GetAltitudeScaled()
+	}
+	if math.IsNaN(altitude) {
+		return nil
+	}
+
+	return &altitude
+}
+
+// Heart rate or cadence of a FIT record, nil for the invalid value 0xFF
+func fitSensorValue(value uint8) *int {
+	if value == 0xFF {
+		return nil
+	}
+
+	v := int(value)
+	return &v
+}
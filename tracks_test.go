@@ -0,0 +1,188 @@
+package main
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func BenchmarkMergeWaypoints(b *testing.B) {
+	existing := syntheticTrack(100_000)
+	added := make([]Waypoint, 0, 100)
+	for _, wp := range existing[len(existing)-100:] {
+		wp.Timestamp = wp.Timestamp.Add(100 * time.Second)
+		added = append(added, wp)
+	}
+	reduce := func(track []Waypoint) []Waypoint {
+		return pruneWaypoints(track, 0.02)
+	}
+
+	for b.Loop() {
+		mergeWaypoints(existing, added, reduce)
+	}
+}
+
+func TestParseGpxFileMergesWithRecorded(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ride.gpx")
+	gpx := `<?xml version="1.0"?>
+<gpx version="1.1" xmlns="http://www.topografix.com/GPX/1/1">
+  <trk><trkseg>
+    <trkpt lat="47.0" lon="11.0"><time>2024-06-01T08:00:00Z</time></trkpt>
+    <trkpt lat="47.1"><time>2024-06-01T08:05:00Z</time></trkpt>
+    <trkpt lat="47.2" lon="11.2"></trkpt>
+    <trkpt lat="47.3" lon="11.3"><time>2024-06-01T10:10:00+02:00</time></trkpt>
+  </trkseg></trk>
+</gpx>`
+	if err := os.WriteFile(path, []byte(gpx), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	parsed, err := parseGpxFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Points without lon or time are skipped
+	if len(parsed) != 2 || !parsed[1].Timestamp.Equal(testStart.Add(10*time.Minute)) {
+		t.Fatalf("parsed %+v, want the first and last point", parsed)
+	}
+
+	// The live tracking recorded the last point as well
+	recorded := []Waypoint{testWaypoint(47.3, 11.3, 10*time.Minute), testWaypoint(47.4, 11.4, 15*time.Minute)}
+	merged := mergeWaypoints(recorded, parsed, func(track []Waypoint) []Waypoint { return track })
+	if len(merged) != 3 {
+		t.Fatalf("merged %d waypoints, want 3 with the duplicate dropped", len(merged))
+	}
+	for i := 1; i < len(merged); i++ {
+		if !merged[i].Timestamp.After(merged[i-1].Timestamp) {
+			t.Errorf("waypoint %d at %v isn't after %v", i, merged[i].Timestamp, merged[i-1].Timestamp)
+		}
+	}
+}
+
+func TestDuplicateTracks(t *testing.T) {
+	// Track of an hour heading north with a waypoint every interval, starting
+	// after past testStart and offset east by km
+	ride := func(interval, after time.Duration, km float64) []Waypoint {
+		var track []Waypoint
+		for d := time.Duration(0); d <= time.Hour; d += interval {
+			lat := 47 + d.Hours()*0.2
+			lng := 11 + km/(111.2*math.Cos(lat*math.Pi/180))
+			track = append(track, testWaypoint(lat, lng, after+d))
+		}
+		return track
+	}
+
+	tracks := [][]Waypoint{
+		ride(10*time.Second, 0, 0),
+		// The same ride exported at a lower resolution with GPS drift
+		ride(time.Minute, 0, 0.02),
+		// Someone else riding at the same time on another road
+		ride(time.Minute, 0, 5),
+		// The same road on the next day
+		ride(time.Minute, 24*time.Hour, 0),
+	}
+
+	got := duplicateTracks(tracks)
+	if want := []bool{false, true, false, false}; !slices.Equal(got, want) {
+		t.Errorf("dropped %v, want %v", got, want)
+	}
+}
+
+func TestParseFitFile(t *testing.T) {
+	parsed, err := parseFitFile(filepath.Join("testdata", "activity.fit"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parsed) != 6 {
+		t.Fatalf("parsed %d waypoints, want 6", len(parsed))
+	}
+
+	for i, wp := range parsed {
+		if lat, lng := 47+0.001*float64(i), 11+0.002*float64(i); !nearCoords(*wp.Location, lat, lng) {
+			t.Errorf("waypoint %d at %v, %v, want %v, %v", i, wp.Location.Latitude, wp.Location.Longitude, lat, lng)
+		}
+		if want := testStart.Add(time.Duration(i) * 10 * time.Second); !wp.Timestamp.Equal(want) || wp.Timestamp.Location() != time.UTC {
+			t.Errorf("waypoint %d at %v, want %v in UTC", i, wp.Timestamp, want)
+		}
+		if wp.Location.Elevation == nil || math.Abs(*wp.Location.Elevation-float64(500+i)) > 0.5 {
+			t.Errorf("waypoint %d elevation = %v, want %d", i, wp.Location.Elevation, 500+i)
+		}
+		if wp.HeartRate == nil || *wp.HeartRate != 120+i {
+			t.Errorf("waypoint %d heart rate = %v, want %d", i, wp.HeartRate, 120+i)
+		}
+	}
+}
+
+func TestParseFitFileInvalidPositions(t *testing.T) {
+	// Records without a fix and at (0, 0) between two valid ones
+	parsed, err := parseFitFile(filepath.Join("testdata", "invalid-positions.fit"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parsed) != 2 || !nearCoords(*parsed[0].Location, 47, 11) || !nearCoords(*parsed[1].Location, 47.003, 11.006) {
+		t.Fatalf("parsed %d waypoints, want the first and last record", len(parsed))
+	}
+	if !parsed[1].Timestamp.Equal(testStart.Add(30 * time.Second)) {
+		t.Errorf("last waypoint at %v, want the fourth record", parsed[1].Timestamp)
+	}
+}
+
+func TestParseFitFileNotFit(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ride.fit")
+	if err := os.WriteFile(path, []byte("<gpx></gpx>"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parseFitFile(path); err == nil {
+		t.Error("parsed a file that isn't FIT")
+	}
+}
+
+func TestParseFitFileCourse(t *testing.T) {
+	// Planned routes exported as FIT courses have no activity
+	parsed, err := parseFitFile(filepath.Join("testdata", "course.fit"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parsed) != 3 || !nearCoords(*parsed[0].Location, 46.5, 10.5) || !nearCoords(*parsed[2].Location, 46.52, 10.5) {
+		t.Fatalf("parsed %d waypoints, want the 3 course records", len(parsed))
+	}
+	if parsed[0].HeartRate != nil {
+		t.Errorf("heart rate = %d, want none", *parsed[0].HeartRate)
+	}
+}
+
+func TestParseFitFileSessions(t *testing.T) {
+	activity, err := os.ReadFile(filepath.Join("testdata", "activity.fit"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	course, err := os.ReadFile(filepath.Join("testdata", "course.fit"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Records of both sessions, and of files chained after each other
+	path := filepath.Join(t.TempDir(), "chained.fit")
+	if err := os.WriteFile(path, slices.Concat(activity, course), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	parsed, err := parseFitFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parsed) != 9 {
+		t.Fatalf("parsed %d waypoints, want 6 of the activity and 3 of the course", len(parsed))
+	}
+	if last := parsed[len(parsed)-1]; !last.Timestamp.Equal(testStart.Add(50 * time.Second)) {
+		t.Errorf("last waypoint at %v, want the end of the second session", last.Timestamp)
+	}
+	for i := 1; i < len(parsed); i++ {
+		if parsed[i].Timestamp.Before(parsed[i-1].Timestamp) {
+			t.Errorf("waypoint %d at %v is before %v", i, parsed[i].Timestamp, parsed[i-1].Timestamp)
+		}
+	}
+}
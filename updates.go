@@ -0,0 +1,349 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Response of /api/updates
+type UpdateResponse struct {
+	// Track of the default rider, or of the only rider if there is just one
+	Waypoints [][]float64 `json:"waypoints"`
+	// Waypoints split wherever the time between two of them exceeds the
+	// segment gap, e.g. on overnight stops
+	Segments [][][]float64          `json:"segments"`
+	Tracks   map[string][][]float64 `json:"tracks"`
+	Images   map[string][]float64   `json:"images"`
+	// Planned routes, kept apart from the recorded waypoints
+	PlannedRoute [][][]float64 `json:"plannedRoute,omitempty"`
+	// Value of after for the next page, empty on the last page
+	NextCursor string `json:"nextCursor,omitempty"`
+	// Number of waypoints visible to the requester and the number of them
+	// in this response
+	Total    int `json:"total"`
+	Returned int `json:"returned"`
+}
+
+// Response of /api/updates?withTime=1, tracks are lists of timed points
+type TimedUpdateResponse struct {
+	Waypoints    []TimedPoint            `json:"waypoints"`
+	Tracks       map[string][]TimedPoint `json:"tracks"`
+	Images       map[string][]float64    `json:"images"`
+	PlannedRoute [][][]float64           `json:"plannedRoute,omitempty"`
+	NextCursor   string                  `json:"nextCursor,omitempty"`
+	Total        int                     `json:"total"`
+	Returned     int                     `json:"returned"`
+}
+
+// Position with the time it was recorded and sensor data if available
+type TimedPoint struct {
+	Lat       float64   `json:"lat"`
+	Lng       float64   `json:"lng"`
+	Time      time.Time `json:"time"`
+	HeartRate *int      `json:"heartRate,omitempty"`
+	Cadence   *int      `json:"cadence,omitempty"`
+}
+
+// Handle incremental track updates
+//
+// Query parameters:
+//
+//	since    RFC3339 timestamp, only waypoints and images after it are returned
+//	until    RFC3339 timestamp, only waypoints and images up to it are returned
+//	segment  1-based segment number, only waypoints of that segment of every
+//	         rider are returned
+//	bbox     minLng,minLat,maxLng,maxLat, only waypoints inside the box are returned
+//	withTime if set to 1, tracks are returned as {lat, lng, time} objects
+//	         instead of [lat, lng] pairs, see TimedUpdateResponse
+//	limit    maximum number of waypoints per page, the response then carries
+//	         a nextCursor as long as more waypoints follow
+//	after    cursor of the previous page, images and planned routes are only
+//	         part of the first
+func (app *App) handleUpdates(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	waypoints := app.visibleWaypoints(r)
+	total := len(waypoints)
+
+	if value := query.Get("segment"); value != "" {
+		segment, err := strconv.Atoi(value)
+		if err != nil || segment < 1 {
+			http.Error(w, "Invalid segment", http.StatusBadRequest)
+			return
+		}
+
+		// Segments are numbered per rider, so segment 3 is the third day
+		// of everyone
+		waypoints = mapRiders(waypoints, func(track []Waypoint) []Waypoint {
+			segments := splitSegments(track, app.config.SegmentGap)
+			if segment > len(segments) {
+				return nil
+			}
+			return segments[segment-1]
+		})
+		if len(waypoints) == 0 {
+			http.Error(w, "Segment not found", http.StatusNotFound)
+			return
+		}
+	}
+
+	var since time.Time
+	if value := query.Get("since"); value != "" {
+		var err error
+		since, err = time.Parse(time.RFC3339, value)
+		if err != nil {
+			http.Error(w, "Invalid since timestamp", http.StatusBadRequest)
+			return
+		}
+
+		i := 0
+		for i < len(waypoints) && !waypoints[i].Timestamp.After(since) {
+			i++
+		}
+		waypoints = waypoints[i:]
+	}
+
+	var until time.Time
+	if value := query.Get("until"); value != "" {
+		var err error
+		until, err = time.Parse(time.RFC3339, value)
+		if err != nil {
+			http.Error(w, "Invalid until timestamp", http.StatusBadRequest)
+			return
+		}
+
+		i := len(waypoints)
+		for i > 0 && waypoints[i-1].Timestamp.After(until) {
+			i--
+		}
+		waypoints = waypoints[:i]
+	}
+
+	if value := query.Get("bbox"); value != "" {
+		box, err := parseBoundingBox(value)
+		if err != nil {
+			http.Error(w, "Invalid bbox", http.StatusBadRequest)
+			return
+		}
+
+		inside := make([]Waypoint, 0, len(waypoints))
+		for _, wp := range waypoints {
+			if box.contains(wp.Location) {
+				inside = append(inside, wp)
+			}
+		}
+		waypoints = inside
+	}
+
+	images := imageCoords(app.visibleImages(r), since, until)
+	plannedRoute := app.plannedRouteCoords()
+	if value := query.Get("after"); value != "" {
+		after, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			http.Error(w, "Invalid after cursor", http.StatusBadRequest)
+			return
+		}
+
+		i := 0
+		for i < len(waypoints) && !waypoints[i].Timestamp.After(after) {
+			i++
+		}
+		waypoints = waypoints[i:]
+		images = make(map[string][]float64)
+		plannedRoute = nil
+	}
+
+	var nextCursor string
+	if value := query.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+
+		if limit < len(waypoints) {
+			// Waypoints of several riders may share a timestamp, don't
+			// split them across pages
+			end := limit
+			for end < len(waypoints) && waypoints[end].Timestamp.Equal(waypoints[end-1].Timestamp) {
+				end++
+			}
+			if end < len(waypoints) {
+				nextCursor = waypoints[end-1].Timestamp.Format(time.RFC3339Nano)
+			}
+			waypoints = waypoints[:end]
+		}
+	}
+
+	var response any
+	if query.Get("withTime") == "1" {
+		tracks := timedTracks(waypoints)
+		response = TimedUpdateResponse{
+			Waypoints:    defaultTrack(tracks),
+			Tracks:       tracks,
+			Images:       images,
+			PlannedRoute: plannedRoute,
+			NextCursor:   nextCursor,
+			Total:        total,
+			Returned:     len(waypoints),
+		}
+	} else {
+		tracks := riderTracks(waypoints)
+		response = UpdateResponse{
+			Waypoints:    defaultTrack(tracks),
+			Segments:     segmentCoords(defaultTrack(groupByRider(waypoints)), app.config.SegmentGap),
+			Tracks:       tracks,
+			Images:       images,
+			PlannedRoute: plannedRoute,
+			NextCursor:   nextCursor,
+			Total:        total,
+			Returned:     len(waypoints),
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// Track of the default rider, or of the only rider if there is just one
+func defaultTrack[T any](tracks map[string][]T) []T {
+	flat, ok := tracks[""]
+	if !ok && len(tracks) == 1 {
+		for _, track := range tracks {
+			flat = track
+		}
+	}
+	if flat == nil {
+		flat = make([]T, 0)
+	}
+
+	return flat
+}
+
+// Tracks as timed points keyed by rider
+func timedTracks(waypoints []Waypoint) map[string][]TimedPoint {
+	tracks := make(map[string][]TimedPoint)
+	for rider, track := range groupByRider(waypoints) {
+		points := make([]TimedPoint, 0, len(track))
+		for _, wp := range track {
+			points = append(points, TimedPoint{
+				Lat:       wp.Location.Latitude,
+				Lng:       wp.Location.Longitude,
+				Time:      wp.Timestamp,
+				HeartRate: wp.HeartRate,
+				Cadence:   wp.Cadence,
+			})
+		}
+		tracks[rider] = points
+	}
+
+	return tracks
+}
+
+// Most recent visible position
+type LatestPosition struct {
+	Lat       float64   `json:"lat"`
+	Lng       float64   `json:"lng"`
+	Timestamp time.Time `json:"timestamp"`
+	Rider     string    `json:"rider,omitempty"`
+}
+
+// Handle the most recent waypoint, for widgets that don't need the track.
+// Viewers without a code get the latest position outside the hidden area.
+// Responds with 204 if there is nothing to show.
+func (app *App) handleLatest(w http.ResponseWriter, r *http.Request) {
+	waypoints := app.visibleWaypoints(r)
+	if len(waypoints) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	latest := waypoints[len(waypoints)-1]
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(LatestPosition{
+		Lat:       latest.Location.Latitude,
+		Lng:       latest.Location.Longitude,
+		Timestamp: latest.Timestamp,
+		Rider:     latest.Rider,
+	})
+}
+
+// Geographic bounding box
+type boundingBox struct {
+	MinLat float64 `json:"minLat"`
+	MinLng float64 `json:"minLng"`
+	MaxLat float64 `json:"maxLat"`
+	MaxLng float64 `json:"maxLng"`
+}
+
+// Handle the bounds of the visible track, so maps can be fitted before the
+// track is loaded. Responds with 204 if there is nothing to show.
+//
+// Query parameters:
+//
+//	images  if set to 1, image locations are included
+func (app *App) handleBounds(w http.ResponseWriter, r *http.Request) {
+	coords := make([]GPSCoords, 0)
+	for _, wp := range app.visibleWaypoints(r) {
+		coords = append(coords, *wp.Location)
+	}
+
+	if r.URL.Query().Get("images") == "1" {
+		for _, image := range imageCoords(app.visibleImages(r), time.Time{}, time.Time{}) {
+			coords = append(coords, GPSCoords{Latitude: image[0], Longitude: image[1]})
+		}
+	}
+
+	if len(coords) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	box := boundingBox{
+		MinLat: coords[0].Latitude,
+		MinLng: coords[0].Longitude,
+		MaxLat: coords[0].Latitude,
+		MaxLng: coords[0].Longitude,
+	}
+	for _, c := range coords[1:] {
+		box.MinLat = min(box.MinLat, c.Latitude)
+		box.MinLng = min(box.MinLng, c.Longitude)
+		box.MaxLat = max(box.MaxLat, c.Latitude)
+		box.MaxLng = max(box.MaxLng, c.Longitude)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(box)
+}
+
+// Parse a bounding box given as minLng,minLat,maxLng,maxLat
+func parseBoundingBox(value string) (boundingBox, error) {
+	parts := strings.Split(value, ",")
+	if len(parts) != 4 {
+		return boundingBox{}, fmt.Errorf("expected 4 values, got %d", len(parts))
+	}
+
+	values := make([]float64, 4)
+	for i, part := range parts {
+		v, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil {
+			return boundingBox{}, err
+		}
+		values[i] = v
+	}
+
+	box := boundingBox{MinLng: values[0], MinLat: values[1], MaxLng: values[2], MaxLat: values[3]}
+	if box.MinLng > box.MaxLng || box.MinLat > box.MaxLat {
+		return boundingBox{}, fmt.Errorf("min values must not exceed max values")
+	}
+
+	return box, nil
+}
+
+func (box boundingBox) contains(c *GPSCoords) bool {
+	return c.Longitude >= box.MinLng && c.Longitude <= box.MaxLng &&
+		c.Latitude >= box.MinLat && c.Latitude <= box.MaxLat
+}
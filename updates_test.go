@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestUpdatesSegment(t *testing.T) {
+	app := testApp(t.TempDir())
+	app.config.SegmentGap = time.Hour
+	app.codes["secret"] = time.Time{}
+
+	// Two days for both riders, anna rides 3 waypoints on the second day
+	// and ben 2
+	app.waypoints = []Waypoint{
+		testRiderWaypoint("anna", 47.00, 11, 0),
+		testRiderWaypoint("ben", 47.00, 12, 0),
+		testRiderWaypoint("anna", 47.01, 11, 10*time.Minute),
+		testRiderWaypoint("ben", 47.01, 12, 10*time.Minute),
+		testRiderWaypoint("anna", 47.10, 11, 24*time.Hour),
+		testRiderWaypoint("ben", 47.10, 12, 24*time.Hour),
+		testRiderWaypoint("anna", 47.11, 11, 24*time.Hour+10*time.Minute),
+		testRiderWaypoint("ben", 47.11, 12, 24*time.Hour+10*time.Minute),
+		testRiderWaypoint("anna", 47.12, 11, 24*time.Hour+20*time.Minute),
+	}
+
+	rec := httptest.NewRecorder()
+	app.handleUpdates(rec, httptest.NewRequest(http.MethodGet, "/api/updates?segment=2&code=secret", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d, want 200", rec.Code)
+	}
+
+	var response UpdateResponse
+	if err := json.NewDecoder(rec.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	if len(response.Tracks["anna"]) != 3 || len(response.Tracks["ben"]) != 2 {
+		t.Fatalf("tracks = %v, want the second day of both riders", response.Tracks)
+	}
+	if first := response.Tracks["anna"][0]; first[0] != 47.10 {
+		t.Errorf("anna's segment starts at %v, want 47.10", first)
+	}
+
+	for query, status := range map[string]int{
+		"segment=3": http.StatusNotFound,
+		"segment=0": http.StatusBadRequest,
+		"segment=x": http.StatusBadRequest,
+	} {
+		rec := httptest.NewRecorder()
+		app.handleUpdates(rec, httptest.NewRequest(http.MethodGet, "/api/updates?code=secret&"+query, nil))
+		if rec.Code != status {
+			t.Errorf("%s: status %d, want %d", query, rec.Code, status)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Largest accepted track upload
+const maxUploadSize = 32 << 20
+
+// Handle track file uploads, a multipart form with the GPX, TCX or FIT file in
+// the field "file". The file is stored in the matching import directory
+// once it parsed and contains waypoints.
+func (app *App) handleUpload(w http.ResponseWriter, r *http.Request) {
+	if !app.hasAccess(r) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxUploadSize)
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		http.Error(w, "Missing track file", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	ext := strings.ToLower(filepath.Ext(header.Filename))
+	var dir string
+	var parse func(path string) ([]Waypoint, error)
+	switch ext {
+	case ".gpx":
+		dir, parse = app.config.GpxDir, parseGpxFile
+	case ".tcx":
+		dir, parse = app.config.TcxDir, parseTcxFile
+	case ".fit":
+		dir, parse = app.config.FitDir, parseFitFile
+	default:
+		http.Error(w, "Only GPX, TCX and FIT files are supported", http.StatusBadRequest)
+		return
+	}
+
+	name := safeFilename(strings.TrimSuffix(filepath.Base(header.Filename), filepath.Ext(header.Filename))) + ext
+	target := filepath.Join(dir, name)
+	if _, err := os.Stat(target); err == nil {
+		http.Error(w, "A track file with this name exists", http.StatusConflict)
+		return
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		slog.Error("Error checking track file", "path", target, "error", err)
+		http.Error(w, "Upload error", http.StatusInternalServerError)
+		return
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		slog.Error("Error creating track directory", "path", dir, "error", err)
+		http.Error(w, "Upload error", http.StatusInternalServerError)
+		return
+	}
+
+	// Parse the upload before it is renamed into the import directory, so
+	// the scans never see a broken file
+	tmp, err := os.CreateTemp(dir, ".upload-*.tmp")
+	if err != nil {
+		slog.Error("Error creating upload file", "path", dir, "error", err)
+		http.Error(w, "Upload error", http.StatusInternalServerError)
+		return
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = io.Copy(tmp, file)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, "Track file too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		slog.Error("Error writing upload file", "path", tmp.Name(), "error", err)
+		http.Error(w, "Upload error", http.StatusInternalServerError)
+		return
+	}
+
+	track, err := parse(tmp.Name())
+	if err != nil || len(track) == 0 {
+		http.Error(w, "Not a track file with waypoints", http.StatusBadRequest)
+		return
+	}
+
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		slog.Error("Error storing track file", "path", target, "error", err)
+		http.Error(w, "Upload error", http.StatusInternalServerError)
+		return
+	}
+	if err := os.Rename(tmp.Name(), target); err != nil {
+		slog.Error("Error storing track file", "path", target, "error", err)
+		http.Error(w, "Upload error", http.StatusInternalServerError)
+		return
+	}
+
+	slog.Info("Stored uploaded track file", "path", target, "waypoint_count", len(track))
+	app.importTrackFiles()
+
+	w.WriteHeader(http.StatusCreated)
+}
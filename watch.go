@@ -0,0 +1,88 @@
+package main
+
+import (
+	"log/slog"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// Quiet time after the last change before rescanning, so uploads of many
+// files trigger a single scan
+const watchDebounce = 2 * time.Second
+
+// Rescan the image and track directories when files in them change. Only
+// the top level of each directory is watched, so the periodic scans keep
+// running to catch changes in subdirectories, missed events and directories
+// that can't be watched, e.g. because they don't exist yet.
+func (app *App) watchDirectories() {
+	go app.periodicImageScan()
+	go app.periodicTrackScan()
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		slog.Warn("Error creating file watcher, relying on periodic scans", "error", err)
+		return
+	}
+
+	if err := watcher.Add(app.config.ImagesDir); err != nil {
+		slog.Warn("Error watching directory, relying on periodic scans", "path", app.config.ImagesDir, "error", err)
+	}
+
+	trackDirs := map[string]struct{}{}
+	for _, dir := range []string{app.config.GpxDir, app.config.TcxDir, app.config.FitDir} {
+		if err := watcher.Add(dir); err != nil {
+			slog.Warn("Error watching directory, relying on periodic scans", "path", dir, "error", err)
+			continue
+		}
+		trackDirs[filepath.Clean(dir)] = struct{}{}
+	}
+
+	imageScan := newDebouncer(watchDebounce, app.scanImages)
+	trackScan := newDebouncer(watchDebounce, app.importTrackFiles)
+
+	go func() {
+		defer watcher.Close()
+
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				// Removed files have to disappear from the map as well
+				if !event.Has(fsnotify.Create) && !event.Has(fsnotify.Write) && !event.Has(fsnotify.Rename) && !event.Has(fsnotify.Remove) {
+					continue
+				}
+
+				if _, ok := trackDirs[filepath.Dir(event.Name)]; ok {
+					trackScan.trigger()
+				} else {
+					imageScan.trigger()
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				slog.Error("Error watching files", "error", err)
+			}
+		}
+	}()
+}
+
+// Runs a function once no trigger arrived for the given delay
+type debouncer struct {
+	delay time.Duration
+	timer *time.Timer
+}
+
+func newDebouncer(delay time.Duration, fn func()) *debouncer {
+	timer := time.AfterFunc(delay, fn)
+	timer.Stop()
+	return &debouncer{delay: delay, timer: timer}
+}
+
+func (d *debouncer) trigger() {
+	d.timer.Reset(d.delay)
+}